@@ -3,6 +3,13 @@ module github.com/elvis-epx/alarme-intelbras
 go 1.25
 
 require (
-	github.com/bigkevmcd/go-configparser v0.0.0-20250311182818-a679eef33309 // indirect
-	github.com/ncruces/go-strftime v0.1.9 // indirect
+	github.com/bigkevmcd/go-configparser v0.0.0-20250311182818-a679eef33309
+	github.com/eclipse/paho.mqtt.golang v1.5.1
+	github.com/ncruces/go-strftime v0.1.9
+)
+
+require (
+	github.com/gorilla/websocket v1.5.3 // indirect
+	golang.org/x/net v0.44.0 // indirect
+	golang.org/x/sync v0.17.0 // indirect
 )
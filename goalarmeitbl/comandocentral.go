@@ -3,6 +3,8 @@ package goalarmeitbl
 import (
     "log"
     "fmt"
+    "errors"
+    "context"
     "time"
     "slices"
     "sync"
@@ -16,11 +18,155 @@ type ComandoCentralSub interface {
     Autenticado(*ComandoCentral)
 }
 
+// Implementado pelas subclasses que produzem dados além de sucesso/fracasso
+type ComandoCentralDados interface {
+    Dados() any
+}
+
+// Relatório detalhado do resultado de um comando
+type RelatorioComando struct {
+    Sucesso bool `json:"sucesso"`
+    Erro string `json:"erro,omitempty"`
+    MotivoNak int `json:"motivo_nak,omitempty"`   // se a central respondeu NAK
+    DescricaoNak string `json:"descricao_nak,omitempty"`
+    NakRepetivel bool `json:"nak_repetivel,omitempty"` // NAK transitório, vale tentar mais tarde
+    MotivoAuth int `json:"motivo_auth,omitempty"` // se a autenticação falhou
+    Dados any `json:"dados,omitempty"`
+    Passos []RelatorioComando `json:"passos,omitempty"` // resultado de cada comando de uma sequência
+    Tentativas int `json:"tentativas,omitempty"` // número de conexões à central
+}
+
+// Motivos de falha de autenticação
+var MotivosAuth = map[int]string{
+    0x01: "senha incorreta",
+    0x02: "versão de software incorreta",
+    0x03: "painel chamará de volta",
+    0x04: "aguardando permissão de usuário",
+}
+
+// Erros possíveis de um comando à central
+var (
+    ErrConexao = errors.New("conexão falhou")
+    ErrConexaoTerminada = errors.New("conexão terminada pela central")
+    ErrTimeout = errors.New("timeout")
+    ErrCentralOcupada = errors.New("central ocupada")
+    ErrRespostaInesperada = errors.New("resposta inesperada")
+)
+
+// Falha de autenticação, com o motivo informado pela central
+type ErroAuth struct {
+    Motivo int
+}
+
+func (e *ErroAuth) Error() string {
+    motivo, ok := MotivosAuth[e.Motivo]
+    if !ok {
+        motivo = "desconhecido"
+    }
+    return fmt.Sprintf("auth falhou por motivo %d (%s)", e.Motivo, motivo)
+}
+
+// Comando recusado pela central (NAK), com o motivo informado
+type ErroNak struct {
+    Motivo NakReason
+}
+
+func (e *ErroNak) Error() string {
+    return fmt.Sprintf("nak motivo %02x (%s)", int(e.Motivo), e.Motivo)
+}
+
+// Erro de resposta inesperada a um comando
+// Invocado pelas subclasses
+func RespostaInesperada(origem string, cmd int) error {
+    return fmt.Errorf("%s: %w %04x", origem, ErrRespostaInesperada, cmd)
+}
+
+// Credenciais de acesso remoto à central
+type Credenciais struct {
+    Senha int
+    TamSenha int // 4 ou 6 dígitos
+}
+
+// Política de novas tentativas, caso a central esteja ocupada (0xf0f7),
+// recuse o comando por motivo transitório, ou a conexão não possa ser estabelecida. O intervalo entre tentativas
+// dobra a cada tentativa, até o máximo, com variação aleatória de ±25%
+type PoliticaRetry struct {
+    Tentativas int // número máximo de tentativas, incluindo a primeira
+    Intervalo time.Duration // intervalo antes da segunda tentativa
+    IntervaloMax time.Duration
+}
+
+// Sem novas tentativas
+var SemRetry = PoliticaRetry{1, 0, 0}
+
+// Política default sugerida para uso interativo
+var PoliticaRetryDefault = PoliticaRetry{3, 2 * time.Second, 30 * time.Second}
+
+// Intervalo de espera antes da tentativa seguinte à tentativa n (1 = primeira)
+func (p PoliticaRetry) intervalo(n int) time.Duration {
+    intervalo := p.Intervalo
+    for i := 1; i < n && intervalo < p.IntervaloMax; i++ {
+        intervalo *= 2
+    }
+    return min(intervalo, p.IntervaloMax)
+}
+
+// Prazo default de resposta a cada pacote enviado à central
+const TimeoutPacoteDefault = 15 * time.Second
+
+// Prazo de resposta a cada pacote em uso (alterado apenas em testes)
+var timeoutPacote = TimeoutPacoteDefault
+
+// Prazo de resposta a cada pacote: o default, ou o prazo restante do contexto, se menor.
+// Um prazo longo não estende a espera por uma central muda, que deve falhar e ser tentada de novo
+func timeout_pacote(ctx context.Context) time.Duration {
+    if prazo, ok := ctx.Deadline(); ok {
+        return min(timeoutPacote, time.Until(prazo))
+    }
+    return timeoutPacote
+}
+
+// Determina se a falha é transitória e justifica nova tentativa
+func erro_repetivel(erro error) bool {
+    var erro_nak *ErroNak
+    if errors.As(erro, &erro_nak) {
+        return erro_nak.Motivo.Repetivel()
+    }
+    return errors.Is(erro, ErrCentralOcupada) || errors.Is(erro, ErrConexao) || errors.Is(erro, ErrTimeout)
+}
+
+// Transporte dos pacotes ISECNet2 de um comando: conexão TCP direta à central (TCPClient)
+// ou multiplexado na conexão da central com o Receptor IP (TunelIsecNet2)
+// O transporte emite os mesmos eventos que TCPClient: Connected ou NotConnected
+// (com um error opcional como carga), Recv, RecvEof, SendEof, Err
+type TransporteComando interface {
+    Send([]byte)
+    Close()
+    Timeout(time.Duration, time.Duration, string) *Timeout
+}
+
+// Estabelece o transporte de uma tentativa, retornando também o canal de eventos
+type ConectorComando func(context.Context) (TransporteComando, chan Event)
+
+// Conector para conexão TCP direta à central
+func ConectorTCP(serveraddr string) ConectorComando {
+    return func(ctx context.Context) (TransporteComando, chan Event) {
+        tcp := NewTCPClientContext(ctx, serveraddr)
+        return tcp, tcp.Events
+    }
+}
+
 // Comando à central.
 // Esta estrutura implementa apenas a infra-estrutura para um comando (conexão e autenticação)
+// Uma sequência de comandos pode ser executada na mesma sessão, com uma única autenticação
 type ComandoCentral struct {
-    tcp *TCPClient
+    ctx context.Context
+    tcp TransporteComando
+    eventos chan Event
+    conectar ConectorComando
     sub ComandoCentralSub
+    subs []ComandoCentralSub
+    passos []RelatorioComando
     resultado chan int
     timeout *Timeout
     senha int
@@ -28,48 +174,145 @@ type ComandoCentral struct {
     buffer []byte
     tratador_resposta TratadorResposta
     status int
+    relatorio RelatorioComando
+    erro error
+    encerrado bool
+    politica PoliticaRetry
+    tentativas int
+    repetir bool
     wg sync.WaitGroup
 }
 
 // Cria novo comando e inicia a conexão à central
 // Usuário deve chamar Resultado(), que bloqueia até a resoluç˜åo
 func NewComandoCentral(sub ComandoCentralSub, serveraddr string, senha int, tam_senha int) *ComandoCentral {
+    return NewSequenciaComandos([]ComandoCentralSub{sub}, serveraddr, senha, tam_senha)
+}
+
+// Cria sequência de comandos, executados em ordem após uma única autenticação
+// A sequência é interrompida no primeiro comando que falhar
+func NewSequenciaComandos(subs []ComandoCentralSub, serveraddr string, senha int, tam_senha int) *ComandoCentral {
+    return NewSequenciaComandosContext(context.Background(), subs, serveraddr, senha, tam_senha)
+}
+
+// Como NewSequenciaComandos, mas interrompida se o contexto for cancelado ou expirar
+func NewSequenciaComandosContext(ctx context.Context, subs []ComandoCentralSub, serveraddr string,
+        senha int, tam_senha int) *ComandoCentral {
+    return NewSequenciaComandosRetry(ctx, subs, serveraddr, senha, tam_senha, SemRetry)
+}
+
+// Como NewSequenciaComandosContext, com novas tentativas conforme a política.
+// Cada tentativa abre nova conexão e retoma a sequência a partir do comando que falhou
+func NewSequenciaComandosRetry(ctx context.Context, subs []ComandoCentralSub, serveraddr string,
+        senha int, tam_senha int, politica PoliticaRetry) *ComandoCentral {
+    return NewSequenciaComandosTransporte(ctx, subs, ConectorTCP(serveraddr), senha, tam_senha, politica)
+}
+
+// Como NewSequenciaComandosRetry, com transporte arbitrário
+func NewSequenciaComandosTransporte(ctx context.Context, subs []ComandoCentralSub, conectar ConectorComando,
+        senha int, tam_senha int, politica PoliticaRetry) *ComandoCentral {
     comando := new(ComandoCentral)
-    comando.tcp = NewTCPClient(serveraddr)
-    comando.sub = sub
+    comando.ctx = ctx
+    comando.conectar = conectar
+    comando.subs = subs
+    comando.sub = subs[0]
     comando.resultado = make(chan int)
-    comando.timeout = comando.tcp.Timeout(15 * time.Second, 0, "Timeout")
     comando.senha = senha
     comando.tam_senha = tam_senha
+    comando.politica = politica
     comando.status = 1 // erro
     log.Print("ComandoCentral: inicio")
 
     comando.wg.Go(func() {
-        for evt := range comando.tcp.Events {
-            switch evt.Name {
-            case "Connected":
-                comando.autenticar()
-            case "NotConnected":
-                fmt.Println("ComandoCentral: Conexão falhou")
-                comando.Bye()
-            case "Recv":
-                buf, _ := evt.Cargo.([]byte)
-                comando.buffer = slices.Concat(comando.buffer, buf)
-                comando.parse()
-            case "Timeout":
-                fmt.Println("ComandoCentral: Timeout")
-                comando.Bye()
-            case "SendEof", "RecvEof", "Err":
-                log.Print("ComandoCentral: Conexão terminada ", evt.Name)
-                comando.Bye()
+        for {
+            comando.sessao(ctx)
+            if !comando.repetir || comando.erro != nil {
+                break
+            }
+            if !comando.aguardar_retry(ctx) {
+                break
             }
         }
+        comando.concluir()
         log.Print("ComandoCentral: fim ----")
+        comando.resultado <-comando.status
     })
 
     return comando
 }
 
+// Uma tentativa: conexão, autenticação e comandos pendentes
+func (comando *ComandoCentral) sessao(ctx context.Context) {
+    comando.tentativas += 1
+    comando.encerrado = false
+    comando.repetir = false
+    comando.buffer = nil
+    comando.tratador_resposta = nil
+    comando.tcp, comando.eventos = comando.conectar(ctx)
+    comando.timeout = comando.tcp.Timeout(timeout_pacote(ctx), 0, "Timeout")
+    log.Printf("ComandoCentral: tentativa %d", comando.tentativas)
+
+    ctx_done := ctx.Done()
+    for {
+        var evt Event
+        select {
+        case <-ctx_done:
+            ctx_done = nil
+            comando.Falha(ctx.Err())
+            continue
+        case e, ok := <-comando.eventos:
+            if !ok {
+                return
+            }
+            evt = e
+        }
+
+        switch evt.Name {
+        case "Connected":
+            comando.autenticar()
+        case "NotConnected":
+            if erro, ok := evt.Cargo.(error); ok {
+                comando.Falha(erro)
+            } else {
+                comando.Falha(ErrConexao)
+            }
+        case "Recv":
+            buf, _ := evt.Cargo.([]byte)
+            comando.buffer = slices.Concat(comando.buffer, buf)
+            comando.parse()
+        case "Timeout":
+            if prazo, ok := ctx.Deadline(); ok && !time.Now().Before(prazo) {
+                // prazo do pacote limitado pelo prazo do contexto, que venceu junto
+                comando.Falha(context.DeadlineExceeded)
+            } else {
+                comando.Falha(ErrTimeout)
+            }
+        case "SendEof", "RecvEof", "Err":
+            log.Print("ComandoCentral: Conexão terminada ", evt.Name)
+            comando.Falha(ErrConexaoTerminada)
+        }
+    }
+}
+
+// Aguarda o intervalo antes da próxima tentativa
+// Retorna false se o contexto foi cancelado durante a espera
+func (comando *ComandoCentral) aguardar_retry(ctx context.Context) bool {
+    intervalo := comando.politica.intervalo(comando.tentativas)
+    log.Printf("ComandoCentral: nova tentativa em %v", intervalo)
+
+    ch := make(chan Event, 1)
+    to := NewTimeout(intervalo, intervalo / 4, ch, "Retry", nil)
+    defer to.Free()
+
+    select {
+    case <-ch:
+        return true
+    case <-ctx.Done():
+        comando.registrar_erro(ctx.Err())
+        return false
+    }
+}
+
 // Envia pacote de autenticação
 func (comando *ComandoCentral) autenticar() {
     log.Print("ComandoCentral: Autenticando")
@@ -90,8 +333,8 @@ func (comando *ComandoCentral) parse() {
     comando.buffer = comando.buffer[comprimento:]
 
     if !PacoteIsecNet2Correto(pacote) {
-        fmt.Println("ComandoCentral: Pacote incorreto, desistindo")
-        comando.Bye()
+        comando.Falha(fmt.Errorf("%w: pacote incorreto", ErrRespostaInesperada))
+        return
     }
 
     cmd, payload := PacoteIsecNet2Parse(pacote)
@@ -99,44 +342,35 @@ func (comando *ComandoCentral) parse() {
 
     if cmd == 0xf0fd {
         comando.parse_nak(payload)
-        comando.Bye()
         return
     } else if cmd == 0xf0f7 {
-        fmt.Println("ComandoCentral: central ocupada")
-        comando.Bye()
+        comando.Falha(ErrCentralOcupada)
         return
     }
 
     if comando.tratador_resposta == nil {
-        log.Print("ComandoCentral: sem tratador")
-        comando.Bye()
+        comando.Falha(fmt.Errorf("%w: resposta sem tratador", ErrRespostaInesperada))
+        return
     }
     comando.tratador_resposta(comando, cmd, payload)
 }
 
 func (comando *ComandoCentral) resposta_autenticacao(_ *ComandoCentral, cmd int, payload []byte) {
     if cmd != 0xf0f0 {
-        fmt.Printf("ComandoCentral: auth resp inesperada %04x\n", cmd)
-        comando.Bye()
+        comando.Falha(RespostaInesperada("auth", cmd))
         return
     }
 
     if len(payload) != 1 {
-        fmt.Println("ComandoCentral: auth resp invalida")
-        comando.Bye()
+        comando.Falha(fmt.Errorf("%w: auth resp inválida", ErrRespostaInesperada))
         return
     }
 
     resposta := int(payload[0])
-    // Possíveis respostas:
-    // 01 = senha incorreta
-    // 02 = versão software incorreta
-    // 03 = painel chamará de volta (?)
-    // 04 = aguardando permissão de usuário (?)
+    // Possíveis respostas em MotivosAuth
 
     if resposta > 0 {
-        fmt.Printf("ComandoCentral: auth falhou por motivo %d\n", resposta)
-        comando.Bye()
+        comando.Falha(&ErroAuth{resposta})
         return
     }
 
@@ -148,17 +382,17 @@ func (comando *ComandoCentral) resposta_autenticacao(_ *ComandoCentral, cmd int,
 // Interpreta pacote "NAK" de erro
 func (comando *ComandoCentral) parse_nak(payload []byte) {
     if len(payload) != 1 {
-        fmt.Println("ComandoCentral: nak invalido")
+        comando.Falha(fmt.Errorf("%w: nak inválido", ErrRespostaInesperada))
         return
     }
-    fmt.Printf("ComandoCentral: nak motivo %02x\n", int(payload[0]))
+    comando.Falha(&ErroNak{NakReason(payload[0])})
 }
 
 // Envia pacote de comando e implanta um tratador da resposta
 // Invocado tanto aqui como pela subclasse
 func (comando *ComandoCentral) EnviarPacote(pacote []byte, tf TratadorResposta) {
     log.Print("ComandoCentral: Enviando ", HexPrint(pacote))
-    comando.timeout.Restart()
+    comando.timeout.Reset(timeout_pacote(comando.ctx), 0)
     comando.tratador_resposta = tf
     comando.tcp.Send(pacote)
     if tf == nil {
@@ -167,9 +401,23 @@ func (comando *ComandoCentral) EnviarPacote(pacote []byte, tf TratadorResposta)
     }
 }
 
-// Encerra a comunicação com a central de forma "civilizada"
+// Conclui com sucesso o comando corrente. Se houver mais comandos na sequência,
+// passa ao próximo, caso contrário encerra a comunicação com a central de forma "civilizada"
 // Invocado pela subclasse
 func (comando *ComandoCentral) Despedida() {
+    passo := RelatorioComando{Sucesso: true}
+    if sub, ok := comando.sub.(ComandoCentralDados); ok {
+        passo.Dados = sub.Dados()
+    }
+    comando.passos = append(comando.passos, passo)
+
+    if len(comando.passos) < len(comando.subs) {
+        log.Printf("ComandoCentral: passo %d concluído", len(comando.passos))
+        comando.sub = comando.subs[len(comando.passos)]
+        comando.sub.Autenticado(comando)
+        return
+    }
+
     log.Print("ComandoCentral: Despedindo")
     pacote := PacoteIsecNet2Bye()
     comando.EnviarPacote(pacote, nil)
@@ -177,16 +425,107 @@ func (comando *ComandoCentral) Despedida() {
     comando.status = 0
 }
 
+// Aborta o comando, registrando o motivo da falha
+// Invocado tanto aqui como pela subclasse
+func (comando *ComandoCentral) Falha(erro error) {
+    log.Print("ComandoCentral: falha: ", erro)
+    // status == 0: tarefa já concluída, falha na despedida é irrelevante
+    if comando.status != 0 && comando.erro == nil {
+        if !comando.encerrado && erro_repetivel(erro) && comando.tentativas < comando.politica.Tentativas {
+            log.Print("ComandoCentral: falha transitória, nova tentativa")
+            comando.repetir = true
+        } else {
+            comando.registrar_erro(erro)
+        }
+    }
+    comando.Bye()
+}
+
+func (comando *ComandoCentral) registrar_erro(erro error) {
+    comando.erro = erro
+    comando.relatorio.Erro = erro.Error()
+    var erro_nak *ErroNak
+    if errors.As(erro, &erro_nak) {
+        comando.relatorio.MotivoNak = int(erro_nak.Motivo)
+        comando.relatorio.DescricaoNak = erro_nak.Motivo.String()
+        comando.relatorio.NakRepetivel = erro_nak.Motivo.Repetivel()
+    }
+    var erro_auth *ErroAuth
+    if errors.As(erro, &erro_auth) {
+        comando.relatorio.MotivoAuth = erro_auth.Motivo
+    }
+}
+
 // Aborta o comando
 // Invocado tanto aqui como pela subclasse
 func (comando *ComandoCentral) Bye() {
-    comando.resultado <-comando.status
+    if comando.encerrado {
+        // e.g. evento NotConnected após cancelamento pelo contexto
+        return
+    }
+    comando.encerrado = true
     // garante que fila de eventos é drenada e fechada
     comando.tcp.Close()
 }
 
+// Consolida o relatório após a última tentativa
+func (comando *ComandoCentral) concluir() {
+    comando.relatorio.Sucesso = comando.status == 0
+    comando.relatorio.Tentativas = comando.tentativas
+
+    // passo corrente falhou, passos seguintes não executados
+    if len(comando.passos) < len(comando.subs) {
+        comando.passos = append(comando.passos, RelatorioComando{Sucesso: false, Erro: comando.relatorio.Erro,
+            MotivoNak: comando.relatorio.MotivoNak, DescricaoNak: comando.relatorio.DescricaoNak,
+            NakRepetivel: comando.relatorio.NakRepetivel, MotivoAuth: comando.relatorio.MotivoAuth})
+    }
+    for len(comando.passos) < len(comando.subs) {
+        comando.passos = append(comando.passos, RelatorioComando{Sucesso: false, Erro: "não executado"})
+    }
+
+    if len(comando.subs) == 1 {
+        comando.relatorio.Dados = comando.passos[0].Dados
+    } else {
+        comando.relatorio.Passos = comando.passos
+    }
+}
+
 // Bloqueia até o comando ser concluído
 // Invocado pelo usuário
 func (comando *ComandoCentral) Resultado() int {
     return <-comando.resultado
 }
+
+// Relatório detalhado do resultado
+// Deve ser invocado após Resultado()
+func (comando *ComandoCentral) Relatorio() RelatorioComando {
+    return comando.relatorio
+}
+
+// Erro que causou a falha do comando, ou nil em caso de sucesso
+// Pode ser testado com errors.Is() contra ErrConexao, ErrTimeout, context.Canceled etc.
+// ou com errors.As() contra *ErroAuth e *ErroNak
+// Deve ser invocado após Resultado()
+func (comando *ComandoCentral) Erro() error {
+    if comando.status == 0 {
+        return nil
+    }
+    if comando.erro == nil {
+        return ErrConexaoTerminada
+    }
+    return comando.erro
+}
+
+// Executa uma sequência de comandos na central, bloqueando até a conclusão.
+// Honra cancelamento e prazo do contexto. Não escreve nada em stdout.
+func Executar(ctx context.Context, serveraddr string, cred Credenciais, subs ...ComandoCentralSub) (RelatorioComando, error) {
+    return ExecutarRetry(ctx, serveraddr, cred, SemRetry, subs...)
+}
+
+// Como Executar, com novas tentativas conforme a política
+func ExecutarRetry(ctx context.Context, serveraddr string, cred Credenciais, politica PoliticaRetry,
+        subs ...ComandoCentralSub) (RelatorioComando, error) {
+    comando := NewSequenciaComandosRetry(ctx, subs, serveraddr, cred.Senha, cred.TamSenha, politica)
+    comando.Resultado() // bloqueia
+    return comando.Relatorio(), comando.Erro()
+}
@@ -0,0 +1,511 @@
+package goalarmeitbl
+
+import (
+    "testing"
+    "log"
+    "net"
+    "io"
+    "os"
+    "bytes"
+    "slices"
+    "context"
+    "errors"
+    "time"
+    "sync/atomic"
+)
+
+// Tratador de comandos da central simulada. Retorna comando e payload da resposta
+// Comando 0 = tratamento default (apenas para autenticação e despedida)
+type TratadorCentralFake func(cmd int, payload []byte) (int, []byte)
+
+// Central simulada, que aceita conexões ISECNet2 até o fim do teste, uma de cada vez
+// Autenticação e despedida têm tratamento default, demais comandos pelo tratador
+func centralfake(t *testing.T, tratador TratadorCentralFake) string {
+    l, err := net.Listen("tcp4", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { l.Close() })
+
+    go func() {
+        for {
+            c, err := l.Accept()
+            if err != nil {
+                return
+            }
+            centralfake_conexao(c, tratador)
+        }
+    }()
+
+    return l.Addr().String()
+}
+
+func centralfake_conexao(c net.Conn, tratador TratadorCentralFake) {
+    defer c.Close()
+
+    buffer := []byte{}
+    tmp := make([]byte, 4096)
+
+    for {
+        n, err := c.Read(tmp)
+        if err != nil {
+            if err != io.EOF {
+                log.Print("centralfake: read ", err)
+            }
+            return
+        }
+        buffer = slices.Concat(buffer, tmp[:n])
+
+        for {
+            comprimento := PacoteIsecNet2Completo(buffer)
+            if comprimento == 0 {
+                break
+            }
+            pacote := buffer[:comprimento]
+            buffer = buffer[comprimento:]
+            cmd, payload := PacoteIsecNet2Parse(pacote)
+            log.Printf("centralfake: recebido %04x", cmd)
+
+            rcmd, rpayload := tratador(cmd, payload)
+            if rcmd != 0 {
+                c.Write(PacoteIsecNet2(rcmd, rpayload))
+            } else if cmd == 0xf0f0 {
+                c.Write(PacoteIsecNet2(0xf0f0, []byte{0x00}))
+            } else if cmd == 0xf0f1 {
+                return
+            }
+        }
+    }
+}
+
+func TestObterFoto(t *testing.T) {
+    fragmentos := [][]byte{{0xff, 0xd8}, {0x01, 0x02, 0x03}, {0xff, 0xd9}}
+
+    addr := centralfake(t, func(cmd int, payload []byte) (int, []byte) {
+        if cmd == 0xf0f0 || cmd == 0xf0f1 {
+            return 0, nil
+        }
+        if cmd != 0x0bb0 || len(payload) != 4 {
+            return 0xf0fd, []byte{0x04}
+        }
+        fragmento := int(payload[3])
+        cabecalho := slices.Concat(payload[0:3], []byte{2, byte(fragmento), byte(len(fragmentos))})
+        return 0x0bb0, slices.Concat(cabecalho, fragmentos[fragmento - 1])
+    })
+
+    folder := t.TempDir()
+    sub := NewObterFoto(0x1234, 1, folder)
+    res := NewComandoCentral(sub, addr, 123456, 6).Resultado()
+    if res != 0 {
+        t.Fatal("Command failed")
+    }
+
+    dados, err := os.ReadFile(sub.Arquivo)
+    if err != nil {
+        t.Fatal("Photo not saved ", err)
+    }
+    if !bytes.Equal(dados, slices.Concat(fragmentos...)) {
+        t.Error("Photo contents differ")
+    }
+}
+
+func TestObterFotoNaoGravada(t *testing.T) {
+    addr := centralfake(t, func(cmd int, payload []byte) (int, []byte) {
+        if cmd == 0xf0f0 {
+            return 0, nil
+        }
+        return 0xf0fd, []byte{0x28}
+    })
+
+    sub := NewObterFoto(0x1234, 0, t.TempDir())
+    res := NewComandoCentral(sub, addr, 123456, 6).Resultado()
+    if res == 0 {
+        t.Error("Command should have failed")
+    }
+    if sub.Fatal {
+        t.Error("NAK 0x28 should not be fatal")
+    }
+}
+
+func TestRelatorioStatus(t *testing.T) {
+    addr := centralfake(t, func(cmd int, payload []byte) (int, []byte) {
+        if cmd == 0x0b4a {
+            return 0x0b4a, hexpayload(statusPayloadReadme)
+        }
+        return 0, nil
+    })
+
+    sub, _ := NewSolicitarStatus(nil)
+    c := NewComandoCentral(sub, addr, 123456, 6)
+    if c.Resultado() != 0 {
+        t.Fatal("Command failed")
+    }
+    relatorio := c.Relatorio()
+    status, ok := relatorio.Dados.(StatusCentral)
+    if !relatorio.Sucesso || !ok || status.Firmware != [3]int{2, 3, 1} {
+        t.Errorf("Unexpected report %v", relatorio)
+    }
+}
+
+func TestRelatorioNak(t *testing.T) {
+    addr := centralfake(t, func(cmd int, payload []byte) (int, []byte) {
+        if cmd == 0x401e {
+            return 0xf0fd, []byte{0x1e}
+        }
+        return 0, nil
+    })
+
+    sub, _ := NewDesativarCentral([]any{1})
+    c := NewComandoCentral(sub, addr, 123456, 6)
+    if c.Resultado() == 0 {
+        t.Fatal("Command should have failed")
+    }
+    relatorio := c.Relatorio()
+    if relatorio.Sucesso || relatorio.MotivoNak != 0x1e || relatorio.Erro == "" ||
+            relatorio.DescricaoNak != "sem permissão para desarmar" || relatorio.NakRepetivel {
+        t.Errorf("Unexpected report %v", relatorio)
+    }
+}
+
+func TestRelatorioAuth(t *testing.T) {
+    addr := centralfake(t, func(cmd int, payload []byte) (int, []byte) {
+        if cmd == 0xf0f0 {
+            return 0xf0f0, []byte{0x01}
+        }
+        return 0, nil
+    })
+
+    sub, _ := NewComandoNulo(nil)
+    c := NewComandoCentral(sub, addr, 123456, 6)
+    if c.Resultado() == 0 {
+        t.Fatal("Command should have failed")
+    }
+    relatorio := c.Relatorio()
+    if relatorio.Sucesso || relatorio.MotivoAuth != 0x01 {
+        t.Errorf("Unexpected report %v", relatorio)
+    }
+}
+
+func TestRelatorioNaoConectado(t *testing.T) {
+    // porta sem servidor
+    l, _ := net.Listen("tcp4", "127.0.0.1:0")
+    addr := l.Addr().String()
+    l.Close()
+
+    sub, _ := NewComandoNulo(nil)
+    c := NewComandoCentral(sub, addr, 123456, 6)
+    if c.Resultado() == 0 {
+        t.Fatal("Command should have failed")
+    }
+    if c.Relatorio().Erro != "conexão falhou" {
+        t.Errorf("Unexpected report %v", c.Relatorio())
+    }
+}
+
+func TestSequenciaComandos(t *testing.T) {
+    addr := centralfake(t, func(cmd int, payload []byte) (int, []byte) {
+        switch cmd {
+        case 0x401e:
+            return 0x401e, nil
+        case 0x4013:
+            return 0xf0fe, nil
+        case 0x0b4a:
+            return 0x0b4a, hexpayload(statusPayloadReadme)
+        }
+        return 0, nil
+    })
+
+    subs, _ := ConstruirComandos(SepararComandos([]string{"desativar 1; limpardisparo; status"}))
+    c := NewSequenciaComandos(subs, addr, 123456, 6)
+    if c.Resultado() != 0 {
+        t.Fatal("Command failed")
+    }
+    relatorio := c.Relatorio()
+    if len(relatorio.Passos) != 3 {
+        t.Fatalf("Unexpected report %v", relatorio)
+    }
+    for _, passo := range relatorio.Passos {
+        if !passo.Sucesso {
+            t.Errorf("Unexpected step report %v", passo)
+        }
+    }
+    if _, ok := relatorio.Passos[2].Dados.(StatusCentral); !ok {
+        t.Errorf("Status missing %v", relatorio.Passos[2])
+    }
+}
+
+func TestSequenciaComandosFalha(t *testing.T) {
+    addr := centralfake(t, func(cmd int, payload []byte) (int, []byte) {
+        switch cmd {
+        case 0x401e:
+            return 0x401e, nil
+        case 0x4013:
+            return 0xf0fd, []byte{0x21}
+        }
+        return 0, nil
+    })
+
+    subs, _ := ConstruirComandos(SepararComandos([]string{"desativar; limpardisparo; status"}))
+    c := NewSequenciaComandos(subs, addr, 123456, 6)
+    if c.Resultado() == 0 {
+        t.Fatal("Command should have failed")
+    }
+    passos := c.Relatorio().Passos
+    if len(passos) != 3 || !passos[0].Sucesso || passos[1].Sucesso || passos[1].MotivoNak != 0x21 || passos[2].Sucesso {
+        t.Errorf("Unexpected report %v", passos)
+    }
+}
+
+func TestExecutarErros(t *testing.T) {
+    addr := centralfake(t, func(cmd int, payload []byte) (int, []byte) {
+        if cmd == 0x4013 {
+            return 0xf0fd, []byte{0x21}
+        }
+        return 0, nil
+    })
+
+    sub, _ := NewLimparDisparo(nil)
+    relatorio, err := Executar(context.Background(), addr, Credenciais{123456, 6}, sub)
+    var erro_nak *ErroNak
+    if !errors.As(err, &erro_nak) || erro_nak.Motivo != 0x21 || relatorio.Sucesso {
+        t.Errorf("Expected NAK error, got %v", err)
+    }
+
+    addr = centralfake(t, func(cmd int, payload []byte) (int, []byte) {
+        if cmd == 0xf0f0 {
+            return 0xf0f0, []byte{0x02}
+        }
+        return 0, nil
+    })
+    sub, _ = NewComandoNulo(nil)
+    _, err = Executar(context.Background(), addr, Credenciais{123456, 6}, sub)
+    var erro_auth *ErroAuth
+    if !errors.As(err, &erro_auth) || erro_auth.Motivo != 0x02 {
+        t.Errorf("Expected auth error, got %v", err)
+    }
+
+    addr = centralfake(t, func(cmd int, payload []byte) (int, []byte) {
+        if cmd == 0x0b4a {
+            return 0xf0f7, nil
+        }
+        return 0, nil
+    })
+    sub, _ = NewSolicitarStatus(nil)
+    _, err = Executar(context.Background(), addr, Credenciais{123456, 6}, sub)
+    if !errors.Is(err, ErrCentralOcupada) {
+        t.Errorf("Expected busy error, got %v", err)
+    }
+
+    addr = centralfake(t, func(cmd int, payload []byte) (int, []byte) {
+        if cmd == 0x0b4a {
+            return 0x0b4b, nil
+        }
+        return 0, nil
+    })
+    sub, _ = NewSolicitarStatus(nil)
+    _, err = Executar(context.Background(), addr, Credenciais{123456, 6}, sub)
+    if !errors.Is(err, ErrRespostaInesperada) {
+        t.Errorf("Expected unexpected response error, got %v", err)
+    }
+
+    sub, _ = NewComandoNulo(nil)
+    relatorio, err = Executar(context.Background(), centralfake(t, func(int, []byte) (int, []byte) { return 0, nil }),
+        Credenciais{123456, 6}, sub)
+    if err != nil || !relatorio.Sucesso {
+        t.Errorf("Expected success, got %v", err)
+    }
+}
+
+func TestExecutarContexto(t *testing.T) {
+    // central que nunca responde ao status (tratamento default ignora o comando)
+    addr := centralfake(t, func(cmd int, payload []byte) (int, []byte) {
+        return 0, nil
+    })
+
+    ctx, cancel := context.WithTimeout(context.Background(), 200 * time.Millisecond)
+    defer cancel()
+    sub := &SolicitarStatus{}
+    inicio := time.Now()
+    _, err := Executar(ctx, addr, Credenciais{123456, 6}, sub)
+    if !errors.Is(err, context.DeadlineExceeded) {
+        t.Errorf("Expected deadline error, got %v", err)
+    }
+    if time.Since(inicio) > 5 * time.Second {
+        t.Errorf("Context deadline not honoured")
+    }
+
+    ctx, cancel = context.WithCancel(context.Background())
+    cancel()
+    _, err = Executar(ctx, addr, Credenciais{123456, 6}, sub)
+    if !errors.Is(err, context.Canceled) {
+        t.Errorf("Expected cancellation error, got %v", err)
+    }
+}
+
+func TestTimeoutPacote(t *testing.T) {
+    if timeout_pacote(context.Background()) != TimeoutPacoteDefault {
+        t.Error("Default packet timeout expected without deadline")
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+    defer cancel()
+    if d := timeout_pacote(ctx); d <= 0 || d > time.Second {
+        t.Errorf("Short deadline should shorten packet timeout, got %v", d)
+    }
+    ctx, cancel = context.WithTimeout(context.Background(), 60 * time.Second)
+    defer cancel()
+    if timeout_pacote(ctx) != TimeoutPacoteDefault {
+        t.Error("Long deadline should keep default packet timeout")
+    }
+}
+
+func TestRetryCentralMuda(t *testing.T) {
+    timeoutPacote = 200 * time.Millisecond
+    t.Cleanup(func() { timeoutPacote = TimeoutPacoteDefault })
+
+    // primeira conexão nunca responde; as seguintes são atendidas normalmente
+    l, err := net.Listen("tcp4", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { l.Close() })
+    go func() {
+        muda, err := l.Accept()
+        if err != nil {
+            return
+        }
+        defer muda.Close()
+        for {
+            c, err := l.Accept()
+            if err != nil {
+                return
+            }
+            centralfake_conexao(c, func(int, []byte) (int, []byte) { return 0, nil })
+        }
+    }()
+
+    // prazo total longo não deve estender a espera pela central muda
+    ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+    defer cancel()
+    politica := PoliticaRetry{3, 50 * time.Millisecond, time.Second}
+    sub, _ := NewComandoNulo(nil)
+    inicio := time.Now()
+    relatorio, err := ExecutarRetry(ctx, l.Addr().String(), Credenciais{123456, 6}, politica, sub)
+    if err != nil || !relatorio.Sucesso || relatorio.Tentativas != 2 {
+        t.Errorf("Expected success on second attempt, got %v %v", relatorio, err)
+    }
+    if time.Since(inicio) > 5 * time.Second {
+        t.Errorf("Silent central held the command for %v", time.Since(inicio))
+    }
+}
+
+func TestRetryOcupada(t *testing.T) {
+    conexoes := 0
+    addr := centralfake(t, func(cmd int, payload []byte) (int, []byte) {
+        if cmd == 0xf0f0 {
+            conexoes += 1
+            if conexoes < 3 {
+                return 0xf0f7, nil
+            }
+        }
+        return 0, nil
+    })
+
+    politica := PoliticaRetry{3, 50 * time.Millisecond, time.Second}
+    sub, _ := NewComandoNulo(nil)
+    relatorio, err := ExecutarRetry(context.Background(), addr, Credenciais{123456, 6}, politica, sub)
+    if err != nil || !relatorio.Sucesso || relatorio.Tentativas != 3 {
+        t.Errorf("Expected success after 3 attempts, got %v %v", err, relatorio)
+    }
+
+    conexoes = 0
+    politica.Tentativas = 2
+    relatorio, err = ExecutarRetry(context.Background(), addr, Credenciais{123456, 6}, politica, sub)
+    if !errors.Is(err, ErrCentralOcupada) || relatorio.Tentativas != 2 {
+        t.Errorf("Expected busy error after 2 attempts, got %v %v", err, relatorio)
+    }
+}
+
+func TestRetrySequencia(t *testing.T) {
+    // central ocupada no segundo comando da primeira conexão
+    ocupada := true
+    var desativar atomic.Int32
+    addr := centralfake(t, func(cmd int, payload []byte) (int, []byte) {
+        switch cmd {
+        case 0x401e:
+            desativar.Add(1)
+            return 0x401e, nil
+        case 0x4013:
+            if ocupada {
+                ocupada = false
+                return 0xf0f7, nil
+            }
+            return 0xf0fe, nil
+        }
+        return 0, nil
+    })
+
+    subs, _ := ConstruirComandos(SepararComandos([]string{"desativar; limpardisparo"}))
+    politica := PoliticaRetry{2, 10 * time.Millisecond, time.Second}
+    relatorio, err := ExecutarRetry(context.Background(), addr, Credenciais{123456, 6}, politica, subs...)
+    if err != nil || relatorio.Tentativas != 2 || len(relatorio.Passos) != 2 {
+        t.Fatalf("Unexpected result %v %v", err, relatorio)
+    }
+    // desativar não deve ser repetido
+    if desativar.Load() != 1 {
+        t.Errorf("Step repeated %d times", desativar.Load())
+    }
+}
+
+func TestRetryNaoConectado(t *testing.T) {
+    l, _ := net.Listen("tcp4", "127.0.0.1:0")
+    addr := l.Addr().String()
+    l.Close()
+
+    politica := PoliticaRetry{3, 10 * time.Millisecond, 20 * time.Millisecond}
+    sub, _ := NewComandoNulo(nil)
+    relatorio, err := ExecutarRetry(context.Background(), addr, Credenciais{123456, 6}, politica, sub)
+    if !errors.Is(err, ErrConexao) || relatorio.Tentativas != 3 {
+        t.Errorf("Expected connection error after 3 attempts, got %v %v", err, relatorio)
+    }
+
+    // contexto cancelado durante a espera
+    politica = PoliticaRetry{3, 10 * time.Second, 10 * time.Second}
+    ctx, cancel := context.WithTimeout(context.Background(), 200 * time.Millisecond)
+    defer cancel()
+    inicio := time.Now()
+    _, err = ExecutarRetry(ctx, addr, Credenciais{123456, 6}, politica, sub)
+    if !errors.Is(err, context.DeadlineExceeded) || time.Since(inicio) > 5 * time.Second {
+        t.Errorf("Expected deadline error, got %v", err)
+    }
+}
+
+func TestPoliticaRetryIntervalo(t *testing.T) {
+    p := PoliticaRetry{5, time.Second, 5 * time.Second}
+    esperado := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}
+    for i, e := range esperado {
+        if p.intervalo(i + 1) != e {
+            t.Errorf("Interval %d: expected %v got %v", i + 1, e, p.intervalo(i + 1))
+        }
+    }
+}
+
+func TestRetryNakTransitorio(t *testing.T) {
+    cheio := true
+    addr := centralfake(t, func(cmd int, payload []byte) (int, []byte) {
+        if cmd == 0x4013 {
+            if cheio {
+                cheio = false
+                return 0xf0fd, []byte{byte(NakBufferCheio)}
+            }
+            return 0xf0fe, nil
+        }
+        return 0, nil
+    })
+
+    politica := PoliticaRetry{2, 10 * time.Millisecond, time.Second}
+    sub, _ := NewLimparDisparo(nil)
+    relatorio, err := ExecutarRetry(context.Background(), addr, Credenciais{123456, 6}, politica, sub)
+    if err != nil || relatorio.Tentativas != 2 {
+        t.Errorf("Expected success after retry, got %v %v", err, relatorio)
+    }
+}
@@ -4,18 +4,136 @@ import (
     "slices"
     "strings"
     "fmt"
+    "log"
+    "strconv"
+    "os"
+    "path/filepath"
+    "time"
 )
 
+// Tipo de argumento de um subcomando
+type TipoArg int
+
+const (
+    ArgInteiro TipoArg = iota
+    ArgTexto
+    ArgZonas // lista de zonas ou faixas (e.g. 3,5,10-12), ou "all"
+)
+
+// Lista de zonas, ou todas as zonas
+type ListaZonas struct {
+    Todas bool
+    Zonas []int
+}
+
+// Interpreta lista de zonas no formato 3,5,10-12 ou "all"
+func ParseListaZonas(texto string, min int, max int) (ListaZonas, error) {
+    res := ListaZonas{}
+    texto = strings.ToLower(strings.TrimSpace(texto))
+    if texto == "all" || texto == "todas" {
+        res.Todas = true
+        return res, nil
+    }
+
+    for _, item := range strings.Split(texto, ",") {
+        inicio_s, fim_s, faixa := strings.Cut(strings.TrimSpace(item), "-")
+        inicio, err := strconv.Atoi(inicio_s)
+        if err != nil {
+            return res, fmt.Errorf("zona inválida: %s", item)
+        }
+        fim := inicio
+        if faixa {
+            fim, err = strconv.Atoi(fim_s)
+            if err != nil || fim < inicio {
+                return res, fmt.Errorf("faixa de zonas inválida: %s", item)
+            }
+        }
+        if inicio < min || fim > max {
+            return res, fmt.Errorf("zona fora da faixa %d-%d: %s", min, max, item)
+        }
+        for zona := inicio; zona <= fim; zona++ {
+            if !slices.Contains(res.Zonas, zona) {
+                res.Zonas = append(res.Zonas, zona)
+            }
+        }
+    }
+
+    return res, nil
+}
+
+// Descritor de um argumento de subcomando
+type DescArg struct {
+    Nome string
+    Tipo TipoArg
+    Opcional bool
+    Default any // valor adotado se opcional e omitido
+    Min int     // faixa de valores válidos, apenas para ArgInteiro
+    Max int
+}
+
 // Construtor de uma implementação/subclasse
-type Constructor func(int) (ComandoCentralSub, string)
+// Recebe os argumentos já validados, na ordem e com os tipos de DescComandoSub.Args
+// (int para ArgInteiro, string para ArgTexto)
+type Constructor func([]any) (ComandoCentralSub, string)
 
 // Descritor de uma subclasse, para usar num mapa string -> descritor
 type DescComandoSub struct {
     ExtraHelp string
-    ExtraParam bool
+    Args []DescArg
     Construtor Constructor
 }
 
+// Sinopse dos argumentos, para texto de ajuda
+func (desc DescComandoSub) Sinopse() string {
+    lista := []string{}
+    for _, arg := range desc.Args {
+        if arg.Opcional {
+            lista = append(lista, "[" + arg.Nome + "]")
+        } else {
+            lista = append(lista, "<" + arg.Nome + ">")
+        }
+    }
+    return strings.Join(lista, " ")
+}
+
+// Valida e converte os argumentos de um subcomando, fornecidos como strings
+// Retorna mensagem de erro não-vazia se houver problema
+func (desc DescComandoSub) ParseArgs(args []string) ([]any, string) {
+    if len(args) > len(desc.Args) {
+        return nil, "Parâmetro extra desnecessário"
+    }
+
+    res := []any{}
+    for i, darg := range desc.Args {
+        if i >= len(args) {
+            if !darg.Opcional {
+                return nil, fmt.Sprintf("Parâmetro %s precisa ser especificado", darg.Nome)
+            }
+            res = append(res, darg.Default)
+            continue
+        }
+
+        switch darg.Tipo {
+        case ArgInteiro:
+            valor, err := strconv.Atoi(args[i])
+            if err != nil || valor < darg.Min || valor > darg.Max {
+                return nil, fmt.Sprintf("Parâmetro %s inválido (faixa %d-%d)", darg.Nome, darg.Min, darg.Max)
+            }
+            res = append(res, valor)
+        case ArgTexto:
+            res = append(res, args[i])
+        case ArgZonas:
+            zonas, err := ParseListaZonas(args[i], darg.Min, darg.Max)
+            if err != nil {
+                return nil, fmt.Sprintf("Parâmetro %s inválido: %v", darg.Nome, err)
+            }
+            res = append(res, zonas)
+        }
+    }
+
+    return res, ""
+}
+
 // Apenas autentica e encerra.
 // Útil para testes, conferir que a senha é válida, etc.
 type ComandoNulo struct {
@@ -25,13 +143,14 @@ func (comando *ComandoNulo) Autenticado(super *ComandoCentral) {
     super.Despedida()
 }
 
-func NewComandoNulo(_ int) (ComandoCentralSub, string) {
+func NewComandoNulo(_ []any) (ComandoCentralSub, string) {
     comando := new(ComandoNulo)
     return comando, ""
 }
 
 // Solicita status da central: partições, disparos, etc.
 type SolicitarStatus struct {
+    Status StatusCentral
 }
 
 func (comando *SolicitarStatus) Autenticado(super *ComandoCentral) {
@@ -39,147 +158,150 @@ func (comando *SolicitarStatus) Autenticado(super *ComandoCentral) {
     super.EnviarPacote(pacote, comando.RespostaStatus)
 }
 
-func bits_para_numeros(octetos []byte, inverso bool) string {
+func lista_numeros(numeros []int) string {
     lista := []string{}
-    for i, octeto := range octetos {
-        for j := range 8 {
-            bit := (octeto & (1 << j))
-            if (bit != 0 && !inverso) || (bit == 0 && inverso) {
-                lista = append(lista, fmt.Sprintf("%d", (1 + j + i * 8)))
-            }
-        }
+    for _, numero := range numeros {
+        lista = append(lista, strconv.Itoa(numero))
     }
     return strings.Join(lista, ", ")
 }
 
-func sim_nao(valor int) string {
-    if valor != 0 {
+func sim_nao(valor bool) string {
+    if valor {
         return "Sim"
     }
     return "Não"
 }
 
+// Representação humanamente legível do status da central
+func TextoStatusCentral(s StatusCentral) string {
+    var b strings.Builder
+    b.WriteString("*******************************************\n")
+    if s.Modelo == 0x01 {
+        b.WriteString("Central AMT-8000\n")
+    } else {
+        b.WriteString("Central de tipo desconhecido\n")
+    }
+    fmt.Fprintf(&b, "Versão de firmware %d.%d.%d\n", s.Firmware[0], s.Firmware[1], s.Firmware[2])
+    b.WriteString("Status geral: \n")
+    var armado = map[int]string{0x00: "Desarmado", 0x01: "Partição(ões) armada(s)", 0x03: "Todas partições armadas"}
+    fmt.Fprintf(&b, "\t %s\n", armado[s.Armado])
+    fmt.Fprintf(&b, "\tZonas em alarme: %s\n", sim_nao(s.ZonasEmAlarme))
+    fmt.Fprintf(&b, "\tZonas canceladas: %s\n", sim_nao(s.ZonasCanceladas))
+    fmt.Fprintf(&b, "\tTodas zonas fechadas: %s\n", sim_nao(s.ZonasFechadas))
+    fmt.Fprintf(&b, "\tSirene: %s\n", sim_nao(s.Sirene))
+    fmt.Fprintf(&b, "\tProblemas: %s\n", sim_nao(s.Problemas))
+    for _, p := range s.Particoes {
+        fmt.Fprintf(&b, "Partição %02d:\n", p.Numero)
+        fmt.Fprintf(&b, "\tStay: %s\n", sim_nao(p.Stay))
+        fmt.Fprintf(&b, "\tDelay de saída: %s\n", sim_nao(p.DelaySaida))
+        fmt.Fprintf(&b, "\tPronto para armar: %s\n", sim_nao(p.ProntoArmar))
+        fmt.Fprintf(&b, "\tAlame ocorreu: %s\n", sim_nao(p.AlarmeOcorreu))
+        fmt.Fprintf(&b, "\tEm alarme: %s\n", sim_nao(p.EmAlarme))
+        fmt.Fprintf(&b, "\tArmado modo stay: %s\n", sim_nao(p.ArmadoStay))
+        fmt.Fprintf(&b, "\tArmado: %s\n", sim_nao(p.Armado))
+    }
+    fmt.Fprintf(&b, "Zonas abertas: %s\n", lista_numeros(s.ZonasAbertas))
+    fmt.Fprintf(&b, "Zonas em alarme: %s\n", lista_numeros(s.ZonasAlarme))
+    fmt.Fprintf(&b, "Zonas em bypass: %s\n", lista_numeros(s.ZonasBypass))
+    fmt.Fprintf(&b, "Sirenes ligadas: %s\n", lista_numeros(s.Sirenes))
+    b.WriteString("*******************************************\n")
+    return b.String()
+}
+
 func (comando *SolicitarStatus) RespostaStatus(super *ComandoCentral, cmd int, payload []byte) {
     if cmd != 0x0b4a {
-        fmt.Printf("RespostaStatus: resp inesperada %04x\n", cmd)
-        super.Bye()
+        super.Falha(RespostaInesperada("RespostaStatus", cmd))
         return
     }
 
-    payload = slices.Concat([]byte{0x00}, payload)
-    fmt.Println()
-    fmt.Println()
-    fmt.Println("*******************************************")
-    if payload[1] == 0x01 {
-        fmt.Println("Central AMT-8000")
-    } else {
-        fmt.Println("Central de tipo desconhecido")
+    status, err := ParseStatusCentral(payload)
+    if err != nil {
+        super.Falha(fmt.Errorf("%w: %v", ErrRespostaInesperada, err))
+        return
     }
-    fmt.Printf("Versão de firmware %d.%d.%d\n", payload[2], payload[3], payload[4])
-    fmt.Println("Status geral: ")
-    var armado = map[int]string{0x00: "Desarmado", 0x01: "Partição(ões) armada(s)", 0x03: "Todas partições armadas"}
-    fmt.Printf("\t %s\n", armado[int(((payload[21] >> 5) & 0x03))])
-    fmt.Printf("\tZonas em alarme: %s\n", sim_nao(int(payload[21] & 0x8)))
-    fmt.Printf("\tZonas canceladas: %s\n", sim_nao(int(payload[21] & 0x10)))
-    fmt.Printf("\tTodas zonas fechadas: %s\n", sim_nao(int(payload[21] & 0x4)))
-    fmt.Printf("\tSirene: %s\n", sim_nao(int(payload[21] & 0x2)))
-    fmt.Printf("\tProblemas: %s\n", sim_nao(int(payload[21] & 0x1)))
-    for particao := range 17 {
-        habilitado := payload[22 + particao] & 0x80
-        if habilitado == 0 {
-            continue
-        }
-        fmt.Printf("Partição %02d:\n", particao)
-        fmt.Printf("\tStay: %s\n", sim_nao(int(payload[22 + particao] & 0x40)))
-        fmt.Printf("\tDelay de saída: %s\n", sim_nao(int(payload[22 + particao] & 0x20)))
-        fmt.Printf("\tPronto para armar: %s\n", sim_nao(int(payload[22 + particao] & 0x10)))
-        fmt.Printf("\tAlame ocorreu: %s\n", sim_nao(int(payload[22 + particao] & 0x08)))
-        fmt.Printf("\tEm alarme: %s\n", sim_nao(int(payload[22 + particao] & 0x04)))
-        fmt.Printf("\tArmado modo stay: %s\n", sim_nao(int(payload[22 + particao] & 0x02)))
-        fmt.Printf("\tArmado: %s\n", sim_nao(int(payload[22 + particao] & 0x01)))
-    }
-    fmt.Printf("Zonas abertas: %s\n", bits_para_numeros(payload[39:47], false))
-    fmt.Printf("Zonas em alarme: %s\n", bits_para_numeros(payload[47:55], false))
-    // fmt.Printf("Zonas ativas: %s\n", bits_para_numeros(payload[55:63], true))
-    fmt.Printf("Zonas em bypass: %s\n", bits_para_numeros(payload[55:63], false))
-    fmt.Printf("Sirenes ligadas: %s\n", bits_para_numeros(payload[63:65], false))
-
-    // TODO interpretar mais campos
-    fmt.Println("*******************************************")
-    fmt.Println()
+    comando.Status = status
 
     super.Despedida()
 }
 
-func NewSolicitarStatus(_ int) (ComandoCentralSub, string) {
+func (comando *SolicitarStatus) Dados() any {
+    return comando.Status
+}
+
+func NewSolicitarStatus(_ []any) (ComandoCentralSub, string) {
     comando := new(SolicitarStatus)
     return comando, ""
 }
 
-// Desarmar o alarme da central
-type DesativarCentral struct {
+// Modo de ativação do alarme (octeto 2 do comando 0x401e)
+type ModoArme byte
+
+const (
+    ModoDesarmar ModoArme = 0x00
+    ModoArmar ModoArme = 0x01
+    ModoStay ModoArme = 0x02
+)
+
+func (modo ModoArme) String() string {
+    switch modo {
+    case ModoDesarmar:
+        return "desarmar"
+    case ModoArmar:
+        return "armar"
+    case ModoStay:
+        return "armar stay"
+    }
+    return fmt.Sprintf("modo %02x", byte(modo))
+}
+
+// Ativar (armar) ou desativar (desarmar) o alarme da central
+type ArmarCentral struct {
     particao int
+    modo ModoArme
 }
 
-func (comando *DesativarCentral) Autenticado(super *ComandoCentral) {
+func (comando *ArmarCentral) Autenticado(super *ComandoCentral) {
     // byte 1: particao (0x01 = 1, 0xff = todas ou sem particao)
-    // byte 2: 0x00 desarmar, 0x01 armar, 0x02 stay
-    pacote := PacoteIsecNet2(0x401e, []byte{byte(comando.particao), 0x00})
-    super.EnviarPacote(pacote, comando.RespostaDesativarCentral)
+    // byte 2: modo
+    pacote := PacoteIsecNet2(0x401e, []byte{byte(comando.particao), byte(comando.modo)})
+    super.EnviarPacote(pacote, comando.RespostaArmarCentral)
 }
 
-func (comando *DesativarCentral) RespostaDesativarCentral(super *ComandoCentral, cmd int, payload []byte) {
+func (comando *ArmarCentral) RespostaArmarCentral(super *ComandoCentral, cmd int, payload []byte) {
     if cmd != 0x401e {
-        fmt.Printf("DesativarCentral: resp inesperada %04x\n", cmd)
-        super.Bye()
+        super.Falha(RespostaInesperada(fmt.Sprintf("ArmarCentral (%s)", comando.modo), cmd))
         return
     }
 
     super.Despedida()
 }
 
-func NewDesativarCentral(particao int) (ComandoCentralSub, string) {
-    comando := new(DesativarCentral)
+// Partição 0 = todas as partições
+func NewArmarCentral(particao int, modo ModoArme) *ArmarCentral {
+    comando := new(ArmarCentral)
     if particao == 0 {
-        // todas as partições
         comando.particao = 0xff
     } else {
         comando.particao = particao
     }
-    return comando, ""
+    comando.modo = modo
+    return comando
 }
 
-// Ativar o alarme, ou seja, armar a central
-type AtivarCentral struct {
-    particao int
+// Desarmar o alarme da central
+func NewDesativarCentral(args []any) (ComandoCentralSub, string) {
+    return NewArmarCentral(args[0].(int), ModoDesarmar), ""
 }
 
-func (comando *AtivarCentral) Autenticado(super *ComandoCentral) {
-    // byte 1: particao (0x01 = 1, 0xff = todas ou sem particao)
-    // byte 2: 0x00 desarmar, 0x01 armar, 0x02 stay
-    pacote := PacoteIsecNet2(0x401e, []byte{byte(comando.particao), 0x01})
-    super.EnviarPacote(pacote, comando.RespostaAtivarCentral)
+// Ativar o alarme, ou seja, armar a central
+func NewAtivarCentral(args []any) (ComandoCentralSub, string) {
+    return NewArmarCentral(args[0].(int), ModoArmar), ""
 }
 
-func (comando *AtivarCentral) RespostaAtivarCentral(super *ComandoCentral, cmd int, payload []byte) {
-    if cmd != 0x401e {
-        fmt.Printf("AtivarCentral: resp inesperada %04x\n", cmd)
-        super.Bye()
-        return
-    }
-
-    super.Despedida()
-}
-
-func NewAtivarCentral(particao int) (ComandoCentralSub, string) {
-    comando := new(AtivarCentral)
-    if particao == 0 {
-        // todas as partições
-        comando.particao = 0xff
-    } else {
-        comando.particao = particao
-    }
-    return comando, ""
+// Ativar o alarme em modo stay (apenas zonas não marcadas como stay)
+func NewAtivarStay(args []any) (ComandoCentralSub, string) {
+    return NewArmarCentral(args[0].(int), ModoStay), ""
 }
 
 // Desligar a sirene, sem limpar o disparo do alarme em si
@@ -194,15 +316,15 @@ func (comando *DesligarSirene) Autenticado(super *ComandoCentral) {
 
 func (comando *DesligarSirene) RespostaDesligarSirene(super *ComandoCentral, cmd int, payload []byte) {
     if cmd != 0xf0fe {
-        fmt.Printf("DesligarSirene: resp inesperada %04x\n", cmd)
-        super.Bye()
+        super.Falha(RespostaInesperada("DesligarSirene", cmd))
         return
     }
 
     super.Despedida()
 }
 
-func NewDesligarSirene(particao int) (ComandoCentralSub, string) {
+func NewDesligarSirene(args []any) (ComandoCentralSub, string) {
+    particao := args[0].(int)
     comando := new(DesligarSirene)
     if particao == 0 {
         // todas as partições
@@ -213,62 +335,85 @@ func NewDesligarSirene(particao int) (ComandoCentralSub, string) {
     return comando, ""
 }
 
-// Bypass de zona, ou seja, desativar a zona de modo que não possa disparar o alarme
+// Bypass de zonas, ou seja, desativar as zonas de modo que não possam disparar o alarme,
+// ou reativação das zonas (remoção do bypass)
+// Todas as zonas da lista são tratadas na mesma sessão
 type BypassZona struct {
-    zona byte
+    zonas ListaZonas
+    bypass bool
+    proxima int
 }
 
 func (comando *BypassZona) Autenticado(super *ComandoCentral) {
-    pacote := PacoteIsecNet2(0x401f, []byte{comando.zona - 1, 0x01})
+    comando.proxima = 0
+    comando.enviar_proxima(super)
+}
+
+func (comando *BypassZona) enviar_proxima(super *ComandoCentral) {
+    flag := byte(0x00)
+    if comando.bypass {
+        flag = 0x01
+    }
+
+    // zona 0xff = todas as zonas (apenas bypass, ver NewReativarZona)
+    zona := byte(0xff)
+    if !comando.zonas.Todas {
+        zona = byte(comando.zonas.Zonas[comando.proxima] - 1)
+    }
+    comando.proxima += 1
+
+    pacote := PacoteIsecNet2(0x401f, []byte{zona, flag})
     super.EnviarPacote(pacote, comando.RespostaBypassZona)
 }
 
 func (comando *BypassZona) RespostaBypassZona(super *ComandoCentral, cmd int, payload []byte) {
     if cmd != 0xf0fe {
-        fmt.Printf("BypassZona: resp inesperada %04x\n", cmd)
-        super.Bye()
+        super.Falha(RespostaInesperada("BypassZona", cmd))
+        return
+    }
+
+    if !comando.zonas.Todas && comando.proxima < len(comando.zonas.Zonas) {
+        comando.enviar_proxima(super)
         return
     }
 
     super.Despedida()
 }
 
-func NewBypassZona(zona int) (ComandoCentralSub, string) {
-    comando := new(BypassZona)
-    if zona < 1 || zona > 254 {
-        return nil, "Zona precisa ser especificada e estar na faixa 1-254"
+func NewBypassZonas(zonas ListaZonas, bypass bool) (*BypassZona, string) {
+    if !zonas.Todas && len(zonas.Zonas) == 0 {
+        return nil, "Zona precisa ser especificada"
     }
-    comando.zona = byte(zona)
+    for _, zona := range zonas.Zonas {
+        if zona < 1 || zona > 254 {
+            return nil, "Zona precisa estar na faixa 1-254"
+        }
+    }
+    comando := new(BypassZona)
+    comando.zonas = zonas
+    comando.bypass = bypass
     return comando, ""
 }
 
-// Reativar zona, ou seja, remover o bypass de zona
-type ReativarZona struct {
-    zona byte
+func NewBypassZona(args []any) (ComandoCentralSub, string) {
+    comando, err := NewBypassZonas(args[0].(ListaZonas), true)
+    if err != "" {
+        return nil, err
+    }
+    return comando, ""
 }
 
-func (comando *ReativarZona) Autenticado(super *ComandoCentral) {
-    pacote := PacoteIsecNet2(0x401f, []byte{comando.zona - 1, 0x00})
+// Reativar zona, ou seja, remover o bypass de zona
+func NewReativarZona(args []any) (ComandoCentralSub, string) {
+    zonas := args[0].(ListaZonas)
     // TODO implementar reativação todas as zonas (0xff + códigos x 0x3f)
-    super.EnviarPacote(pacote, comando.RespostaReativarZona)
-}
-
-func (comando *ReativarZona) RespostaReativarZona(super *ComandoCentral, cmd int, payload []byte) {
-    if cmd != 0xf0fe {
-        fmt.Printf("ReativarZona: resp inesperada %04x\n", cmd)
-        super.Bye()
-        return
+    if zonas.Todas {
+        return nil, "Reativação de todas as zonas não suportada, especifique as zonas"
     }
-
-    super.Despedida()
-}
-
-func NewReativarZona(zona int) (ComandoCentralSub, string) {
-    comando := new(ReativarZona)
-    if zona < 1 || zona > 254 {
-        return nil, "Zona precisa ser especificada e estar na faixa 1-254"
+    comando, err := NewBypassZonas(zonas, false)
+    if err != "" {
+        return nil, err
     }
-    comando.zona = byte(zona)
     return comando, ""
 }
 
@@ -283,32 +428,175 @@ func (comando *LimparDisparo) Autenticado(super *ComandoCentral) {
 
 func (comando *LimparDisparo) RespostaLimparDisparo(super *ComandoCentral, cmd int, payload []byte) {
     if cmd != 0xf0fe {
-        fmt.Printf("LimparDisparo: resp inesperada %04x\n", cmd)
-        super.Bye()
+        super.Falha(RespostaInesperada("LimparDisparo", cmd))
         return
     }
 
     super.Despedida()
 }
 
-func NewLimparDisparo(_ int) (ComandoCentralSub, string) {
+func NewLimparDisparo(_ []any) (ComandoCentralSub, string) {
     comando := new(LimparDisparo)
     return comando, ""
 }
 
+// Download de foto associada a evento 0xb5 (sensor com câmera, e.g. IVP-8000 Pet Cam)
+// A foto é obtida em fragmentos, reunidos e gravados num arquivo JPEG
+type ObterFoto struct {
+    indice int
+    nrfoto int
+    folder string
+    fragmento int
+    jpeg []byte
+    // Nome do arquivo gravado, em caso de sucesso
+    Arquivo string
+    // Resposta da central indica que não adianta tentar novamente
+    Fatal bool
+}
+
+func (comando *ObterFoto) Autenticado(super *ComandoCentral) {
+    comando.fragmento = 1 // fragmento 1 sempre existe
+    comando.jpeg = nil
+    comando.obtem_fragmento(super)
+}
+
+func (comando *ObterFoto) obtem_fragmento(super *ComandoCentral) {
+    log.Printf("ObterFoto: obtendo fragmento %d", comando.fragmento)
+    payload := slices.Concat(BE16(comando.indice), []byte{byte(comando.nrfoto), byte(comando.fragmento)})
+    pacote := PacoteIsecNet2(0x0bb0, payload)
+    super.EnviarPacote(pacote, comando.RespostaFragmento)
+}
+
+func (comando *ObterFoto) RespostaFragmento(super *ComandoCentral, cmd int, payload []byte) {
+    if cmd != 0x0bb0 {
+        super.Falha(RespostaInesperada("ObterFoto", cmd))
+        return
+    }
+
+    if len(payload) < 6 {
+        comando.Fatal = true
+        super.Falha(fmt.Errorf("ObterFoto: %w: fragmento muito curto", ErrRespostaInesperada))
+        return
+    }
+
+    indice := ParseBE16(payload[0:2])
+    foto := int(payload[2])
+    // nr_fotos := int(payload[3])
+    fragmento := int(payload[4])
+    nr_fragmentos := int(payload[5])
+
+    if indice != comando.indice || foto != comando.nrfoto || fragmento != comando.fragmento {
+        comando.Fatal = true
+        super.Falha(fmt.Errorf("ObterFoto: %w: fragmento %d:%d:%d", ErrRespostaInesperada, indice, foto, fragmento))
+        return
+    }
+
+    comando.jpeg = slices.Concat(comando.jpeg, payload[6:])
+
+    if fragmento < nr_fragmentos {
+        comando.fragmento += 1
+        comando.obtem_fragmento(super)
+        return
+    }
+
+    nome := fmt.Sprintf("imagem.%d.%d.%.6f.jpeg", indice, foto, float64(time.Now().UnixMicro()) / 1e6)
+    arquivo := filepath.Join(comando.folder, nome)
+    if err := os.WriteFile(arquivo, comando.jpeg, 0644); err != nil {
+        comando.Fatal = true
+        super.Falha(fmt.Errorf("ObterFoto: falha ao gravar: %w", err))
+        return
+    }
+    log.Print("ObterFoto: foto gravada em ", arquivo)
+    comando.Arquivo = arquivo
+
+    super.Despedida()
+}
+
+func (comando *ObterFoto) Dados() any {
+    return map[string]string{"arquivo": comando.Arquivo}
+}
+
+func NewObterFotoSub(args []any) (ComandoCentralSub, string) {
+    return NewObterFoto(args[0].(int), args[1].(int), args[2].(string)), ""
+}
+
+func NewObterFoto(indice int, nrfoto int, folder string) *ObterFoto {
+    comando := new(ObterFoto)
+    comando.indice = indice
+    comando.nrfoto = nrfoto
+    comando.folder = folder
+    return comando
+}
+
+// Separa argumentos de linha de comando em comandos delimitados por ";"
+// O separador pode ser um argumento isolado ou estar junto a outros
+// (e.g. "desativar 1; limpardisparo; status" ou desativar 1 \; status)
+func SepararComandos(args []string) [][]string {
+    comandos := [][]string{{}}
+    for _, arg := range args {
+        for i, parte := range strings.Split(arg, ";") {
+            if i > 0 {
+                comandos = append(comandos, []string{})
+            }
+            atual := &comandos[len(comandos) - 1]
+            *atual = append(*atual, strings.Fields(parte)...)
+        }
+    }
+    return slices.DeleteFunc(comandos, func(c []string) bool { return len(c) == 0 })
+}
+
+// Constrói as subclasses de uma sequência de comandos, cada um no formato
+// <comando> [parâmetros], conforme Subcomandos
+func ConstruirComandos(comandos [][]string) ([]ComandoCentralSub, string) {
+    if len(comandos) == 0 {
+        return nil, "Comando não especificado"
+    }
+
+    subs := []ComandoCentralSub{}
+    for _, comando := range comandos {
+        descritor, ok := Subcomandos[comando[0]]
+        if !ok {
+            return nil, fmt.Sprintf("Comando não reconhecido: %s", comando[0])
+        }
+
+        args, errstring := descritor.ParseArgs(comando[1:])
+        if errstring != "" {
+            return nil, fmt.Sprintf("%s: %s", comando[0], errstring)
+        }
+
+        sub, errstring := descritor.Construtor(args)
+        if errstring != "" {
+            return nil, fmt.Sprintf("%s: %s", comando[0], errstring)
+        }
+        subs = append(subs, sub)
+    }
+
+    return subs, ""
+}
+
 // Lista de comandos disponíveis
 
 var Subcomandos map[string]DescComandoSub
 
 func init() {
+    particao := []DescArg{{"partição", ArgInteiro, true, 0, 0, 255}}
+    zonas := []DescArg{{"zonas", ArgZonas, false, nil, 1, 254}}
+    foto := []DescArg{
+        {"índice", ArgInteiro, false, nil, 0, 65535},
+        {"nrfoto", ArgInteiro, false, nil, 0, 255},
+        {"pasta", ArgTexto, true, ".", 0, 0},
+    }
+
     Subcomandos = map[string]DescComandoSub{
-        "nulo": DescComandoSub{"", false, NewComandoNulo},
-        "status": DescComandoSub{"", false, NewSolicitarStatus},
-        "ativar": DescComandoSub{"[partição] (se omitida, ativa todas)", true, NewAtivarCentral},
-        "desativar": DescComandoSub{"[partição] (se omitida, desativa todas)", true, NewDesativarCentral},
-        "desligarsirene": DescComandoSub{"[partição] (se omitida, desliga todas)", true, NewDesligarSirene},
-        "limpardisparo": DescComandoSub{"", false, NewLimparDisparo},
-        "bypass": DescComandoSub{"<zona> (obrigatório especificar zona)", true, NewBypassZona},
-        "cancelbypass": DescComandoSub{"<zona> (obrigatório especificar zona)", true, NewReativarZona},
+        "nulo": DescComandoSub{"", nil, NewComandoNulo},
+        "status": DescComandoSub{"", nil, NewSolicitarStatus},
+        "ativar": DescComandoSub{"(se partição omitida, ativa todas)", particao, NewAtivarCentral},
+        "ativarstay": DescComandoSub{"(se partição omitida, ativa todas em modo stay)", particao, NewAtivarStay},
+        "desativar": DescComandoSub{"(se partição omitida, desativa todas)", particao, NewDesativarCentral},
+        "desligarsirene": DescComandoSub{"(se partição omitida, desliga todas)", particao, NewDesligarSirene},
+        "limpardisparo": DescComandoSub{"", nil, NewLimparDisparo},
+        "bypass": DescComandoSub{"(lista de zonas e faixas, e.g. 3,5,10-12, ou all)", zonas, NewBypassZona},
+        "cancelbypass": DescComandoSub{"(lista de zonas e faixas, e.g. 3,5,10-12)", zonas, NewReativarZona},
+        "foto": DescComandoSub{"(baixa foto de evento para a pasta, default pasta corrente)", foto, NewObterFotoSub},
     }
 }
@@ -0,0 +1,192 @@
+package goalarmeitbl
+
+import (
+    "testing"
+    "strings"
+    "slices"
+    "bytes"
+)
+
+func TestParseArgs(t *testing.T) {
+    desc := Subcomandos["foto"]
+
+    args, err := desc.ParseArgs([]string{"300", "1"})
+    if err != "" || args[0].(int) != 300 || args[1].(int) != 1 || args[2].(string) != "." {
+        t.Errorf("failed I %v %s", args, err)
+    }
+
+    args, err = desc.ParseArgs([]string{"300", "1", "/tmp"})
+    if err != "" || args[2].(string) != "/tmp" {
+        t.Errorf("failed II %v %s", args, err)
+    }
+
+    _, err = desc.ParseArgs([]string{"300"})
+    if err == "" {
+        t.Errorf("failed III")
+    }
+
+    _, err = desc.ParseArgs([]string{"300", "256"})
+    if err == "" {
+        t.Errorf("failed IV")
+    }
+
+    _, err = desc.ParseArgs([]string{"300", "1", "/tmp", "x"})
+    if err == "" {
+        t.Errorf("failed V")
+    }
+
+    args, err = Subcomandos["ativar"].ParseArgs(nil)
+    if err != "" || args[0].(int) != 0 {
+        t.Errorf("failed VI %v %s", args, err)
+    }
+
+    _, err = Subcomandos["bypass"].ParseArgs([]string{"0"})
+    if err == "" {
+        t.Errorf("failed VII")
+    }
+
+    if Subcomandos["foto"].Sinopse() != "<índice> <nrfoto> [pasta]" {
+        t.Errorf("failed VIII")
+    }
+}
+
+func TestTextoStatusCentral(t *testing.T) {
+    s, _ := ParseStatusCentral(hexpayload(statusPayloadReadme))
+    texto := TextoStatusCentral(s)
+    esperado := "*******************************************\n" +
+        "Central AMT-8000\n" +
+        "Versão de firmware 2.3.1\n" +
+        "Status geral: \n" +
+        "\t Partição(ões) armada(s)\n" +
+        "\tZonas em alarme: Não\n" +
+        "\tZonas canceladas: Não\n" +
+        "\tTodas zonas fechadas: Sim\n" +
+        "\tSirene: Não\n" +
+        "\tProblemas: Não\n"
+    if !strings.HasPrefix(texto, esperado) {
+        t.Errorf("failed I %s", texto)
+    }
+    if !strings.Contains(texto, "Partição 01:\n\tStay: Não\n") || !strings.Contains(texto, "\tArmado: Sim\n") {
+        t.Errorf("failed II %s", texto)
+    }
+
+    s, _ = ParseStatusCentral(hexpayload(statusPayloadZonas))
+    texto = TextoStatusCentral(s)
+    if !strings.Contains(texto, "Zonas abertas: 1, 3, 9\n") || !strings.Contains(texto, "Zonas em bypass: 16\n") {
+        t.Errorf("failed III %s", texto)
+    }
+}
+
+func TestAtivarStay(t *testing.T) {
+    recebido := make(chan []byte, 1)
+    addr := centralfake(t, func(cmd int, payload []byte) (int, []byte) {
+        if cmd == 0x401e {
+            recebido <-payload
+            return 0x401e, nil
+        }
+        return 0, nil
+    })
+
+    sub, _ := Subcomandos["ativarstay"].Construtor([]any{2})
+    if NewComandoCentral(sub, addr, 123456, 6).Resultado() != 0 {
+        t.Fatal("Command failed")
+    }
+    payload := <-recebido
+    if len(payload) != 2 || payload[0] != 2 || ModoArme(payload[1]) != ModoStay {
+        t.Errorf("Unexpected payload %v", payload)
+    }
+}
+
+func TestParseListaZonas(t *testing.T) {
+    zonas, err := ParseListaZonas("3,5,10-12", 1, 254)
+    if err != nil || zonas.Todas || !slices.Equal(zonas.Zonas, []int{3, 5, 10, 11, 12}) {
+        t.Errorf("failed I %v %v", zonas, err)
+    }
+
+    zonas, err = ParseListaZonas("ALL", 1, 254)
+    if err != nil || !zonas.Todas {
+        t.Errorf("failed II %v %v", zonas, err)
+    }
+
+    zonas, err = ParseListaZonas("4, 4,3-4", 1, 254)
+    if err != nil || !slices.Equal(zonas.Zonas, []int{4, 3}) {
+        t.Errorf("failed III %v %v", zonas, err)
+    }
+
+    for _, invalido := range []string{"", "x", "5-3", "0", "250-255", "1,,2", "3-"} {
+        _, err = ParseListaZonas(invalido, 1, 254)
+        if err == nil {
+            t.Errorf("failed IV %s", invalido)
+        }
+    }
+}
+
+func TestBypassZonas(t *testing.T) {
+    recebido := [][]byte{}
+    addr := centralfake(t, func(cmd int, payload []byte) (int, []byte) {
+        if cmd == 0x401f {
+            recebido = append(recebido, payload)
+            return 0xf0fe, nil
+        }
+        return 0, nil
+    })
+
+    args, _ := Subcomandos["cancelbypass"].ParseArgs([]string{"3,10-11"})
+    sub, _ := Subcomandos["cancelbypass"].Construtor(args)
+    if NewComandoCentral(sub, addr, 123456, 6).Resultado() != 0 {
+        t.Fatal("Command failed")
+    }
+    esperado := [][]byte{{2, 0}, {9, 0}, {10, 0}}
+    if !slices.EqualFunc(recebido, esperado, bytes.Equal) {
+        t.Errorf("Unexpected payloads %v", recebido)
+    }
+}
+
+func TestBypassTodas(t *testing.T) {
+    recebido := [][]byte{}
+    addr := centralfake(t, func(cmd int, payload []byte) (int, []byte) {
+        if cmd == 0x401f {
+            recebido = append(recebido, payload)
+            return 0xf0fe, nil
+        }
+        return 0, nil
+    })
+
+    args, _ := Subcomandos["bypass"].ParseArgs([]string{"all"})
+    sub, _ := Subcomandos["bypass"].Construtor(args)
+    if NewComandoCentral(sub, addr, 123456, 6).Resultado() != 0 {
+        t.Fatal("Command failed")
+    }
+    if len(recebido) != 1 || !bytes.Equal(recebido[0], []byte{0xff, 0x01}) {
+        t.Errorf("Unexpected payloads %v", recebido)
+    }
+}
+
+func TestCancelBypassTodas(t *testing.T) {
+    args, err := Subcomandos["cancelbypass"].ParseArgs([]string{"all"})
+    if err != "" {
+        t.Fatal(err)
+    }
+    if sub, err := Subcomandos["cancelbypass"].Construtor(args); sub != nil || err == "" {
+        t.Error("Reactivation of all zones should be rejected")
+    }
+}
+
+func TestSepararComandos(t *testing.T) {
+    comandos := SepararComandos([]string{"desativar 1; limpardisparo;", "status"})
+    esperado := [][]string{{"desativar", "1"}, {"limpardisparo"}, {"status"}}
+    if !slices.EqualFunc(comandos, esperado, slices.Equal) {
+        t.Errorf("failed I %v", comandos)
+    }
+
+    comandos = SepararComandos([]string{"desativar", "1", ";", "status"})
+    esperado = [][]string{{"desativar", "1"}, {"status"}}
+    if !slices.EqualFunc(comandos, esperado, slices.Equal) {
+        t.Errorf("failed II %v", comandos)
+    }
+
+    _, err := ConstruirComandos(SepararComandos([]string{"desativar 1; bypass"}))
+    if err == "" {
+        t.Errorf("failed III")
+    }
+}
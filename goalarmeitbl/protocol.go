@@ -2,6 +2,7 @@ package goalarmeitbl
 
 import (
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
@@ -275,6 +276,223 @@ func PacoteIsecNet2Bye() []byte {
     return PacoteIsecNet2(0xf0f1, nil)
 }
 
+// Testa se o buffer recebido pelo Receptor IP inicia com um pacote ISECNet2 multiplexado
+// (resposta a um comando enviado pela própria conexão da central), e não com um frame RIP.
+// Pacotes ISECNet2 iniciam com o ID da central (0x0000) ou o nosso (0x8fff), que não
+// coincidem com frames RIP úteis.
+// Retorna se é ISECNet2, e o comprimento do pacote (0 = incompleto)
+func PacoteIsecNet2Multiplexado(dados []byte) (bool, int) {
+    if len(dados) < 2 {
+        return false, 0
+    }
+    id := ParseBE16(dados[0:2])
+    if id != 0x0000 && id != 0x8fff {
+        return false, 0
+    }
+    return true, PacoteIsecNet2Completo(dados)
+}
+
+// Motivo de recusa de um comando ISECNet2 (NAK, comando 0xf0fd)
+type NakReason int
+
+const (
+    NakChecksum NakReason = 0x01
+    NakBufferCheio NakReason = 0x1d
+    NakAutenticacao NakReason = 0x1f
+    NakSemFoto NakReason = 0x23
+    NakIndiceFoto NakReason = 0x24
+    NakFragmentoFoto NakReason = 0x25
+    NakFotoNaoGravada NakReason = 0x28
+    NakComandoInvalido NakReason = 0xfe
+    NakNaoEspecificado NakReason = 0xff
+)
+
+type descNak struct {
+    descricao string
+    repetivel bool // erro transitório, vale a pena tentar novamente mais tarde
+}
+
+// Nem todos os motivos se aplicam a todos os comandos
+var tabelaNak = map[NakReason]descNak{
+    0x00: {"mensagem ok", false}, // por que NAK então? ACK = cmd 0xf0fe
+    0x01: {"erro de checksum", false}, // erros de codificação do próprio pacote, repetir não adianta
+    0x02: {"número de bytes da mensagem", false},
+    0x03: {"número de bytes do parâmetro", false},
+    0x04: {"parâmetro inexistente", false},
+    0x05: {"índice parâmetro", false},
+    0x06: {"valor máximo", false},
+    0x07: {"valor mínimo", false},
+    0x08: {"quantidade de campos", false},
+    0x09: {"nibble 0-9", false},
+    0x0a: {"nibble 1-a", false},
+    0x0b: {"nibble 0-f", false},
+    0x0c: {"nibble 1-f-ex-b-c", false},
+    0x0d: {"ASCII", false},
+    0x0e: {"29 de fevereiro", false},
+    0x0f: {"dia inválido", false},
+    0x10: {"mês inválido", false},
+    0x11: {"ano inválido", false},
+    0x12: {"hora inválida", false},
+    0x13: {"minuto inválido", false},
+    0x14: {"segundo inválido", false},
+    0x15: {"tipo de comando inválido", false},
+    0x16: {"tecla especial", false},
+    0x17: {"número de dígitos", false},
+    0x18: {"número de dígitos senha", false},
+    0x19: {"senha incorreta", false}, // normalmente reportado na resposta da autenticação
+    0x1a: {"partição inexistente", false},
+    0x1b: {"usuário sem permissão na partição", false},
+    0x1c: {"sem permissão programar", false},
+    0x1d: {"buffer de recepção cheio", true},
+    0x1e: {"sem permissão para desarmar", false},
+    0x1f: {"necessária autenticação prévia", false},
+    0x20: {"sem zonas habilitadas", false},
+    0x21: {"sem permissão para comando", false},
+    0x22: {"sem partições definidas", false},
+    0x23: {"evento sem foto associada", false},
+    0x24: {"índice foto inválido", false},
+    0x25: {"fragmento foto inválido", false},
+    0x26: {"sistema não particionado", false},
+    0x27: {"zonas abertas", false},
+    0x28: {"ainda gravando foto", true}, // ou transferindo do sensor
+    0x29: {"acesso mobile desabilitado", false},
+    0x2a: {"operação não permitida", false},
+    0x2b: {"memória RF vazia", false},
+    0x2c: {"memória RF ocupada", true},
+    0x2d: {"senha repetida", false},
+    0x2e: {"falha ativação/desativação", false},
+    0x2f: {"sem permissão arme stay", false},
+    0x30: {"desative a central", false},
+    0x31: {"reset bloqueado", false},
+    0x32: {"teclado bloqueado", false},
+    0x33: {"recebimento de foto falhou", true},
+    0x34: {"não conectado ao servidor", true},
+    0x35: {"teclado sem permissão", false},
+    0x36: {"partição sem zonas stay", false},
+    0x37: {"sem permissão bypass", false},
+    0x38: {"firmware corrompido", false},
+    0xfe: {"comando inválido", false},
+    // não documentado, mas observado se checksum ou tamanho do pacote errado
+    0xff: {"erro não especificado", false},
+}
+
+// Descrição humanamente legível do motivo
+func (m NakReason) String() string {
+    desc, ok := tabelaNak[m]
+    if !ok {
+        return "desconhecido"
+    }
+    return desc.descricao
+}
+
+// Informa se o motivo é transitório, ou seja, o comando pode ter sucesso se repetido
+// Motivos desconhecidos são considerados permanentes
+func (m NakReason) Repetivel() bool {
+    return tabelaNak[m].repetivel
+}
+
+// Converte mapa de bits em lista de números (bit 0 do primeiro octeto = 1)
+func BitsParaNumeros(octetos []byte) []int {
+    lista := []int{}
+    for i, octeto := range octetos {
+        for j := range 8 {
+            if (octeto & (1 << j)) != 0 {
+                lista = append(lista, 1 + j + i * 8)
+            }
+        }
+    }
+    return lista
+}
+
+// Octetos em bruto, representados em JSON como dígitos hexadecimais
+type OctetosHex []byte
+
+func (o OctetosHex) MarshalJSON() ([]byte, error) {
+    return json.Marshal(HexPrint(o))
+}
+
+type StatusParticao struct {
+    Numero int `json:"numero"`
+    Stay bool `json:"stay"`
+    DelaySaida bool `json:"delay_saida"`
+    ProntoArmar bool `json:"pronto_armar"`
+    AlarmeOcorreu bool `json:"alarme_ocorreu"`
+    EmAlarme bool `json:"em_alarme"`
+    ArmadoStay bool `json:"armado_stay"`
+    Armado bool `json:"armado"`
+}
+
+// Status da central, resposta ao comando ISECNet2 0x0b4a
+type StatusCentral struct {
+    Modelo int `json:"modelo"` // 0x01 = AMT-8000
+    Firmware [3]int `json:"firmware"`
+    Armado int `json:"armado"` // 0x00 desarmado, 0x01 partição(ões) armada(s), 0x03 todas partições armadas
+    ZonasEmAlarme bool `json:"zonas_em_alarme"`
+    ZonasCanceladas bool `json:"zonas_canceladas"`
+    ZonasFechadas bool `json:"zonas_fechadas"`
+    Sirene bool `json:"sirene"`
+    Problemas bool `json:"problemas"`
+    Particoes []StatusParticao `json:"particoes"` // apenas as habilitadas
+    ZonasAbertas []int `json:"zonas_abertas"`
+    ZonasAlarme []int `json:"zonas_alarme"`
+    ZonasBypass []int `json:"zonas_bypass"`
+    Sirenes []int `json:"sirenes"`
+    // Campos ainda não interpretados
+    Octetos5a20 OctetosHex `json:"octetos_5_20"` // octetos 5 a 20 da documentação (base 1)
+    Octetos65 OctetosHex `json:"octetos_65"`     // octetos 65 em diante
+}
+
+// Interpreta o payload da resposta de status 0x0b4a
+func ParseStatusCentral(payload []byte) (StatusCentral, error) {
+    s := StatusCentral{}
+
+    if len(payload) < 64 {
+        return s, fmt.Errorf("ParseStatusCentral: tamanho inesperado %d", len(payload))
+    }
+
+    // Documentação é base 1
+    payload = slices.Concat([]byte{0x00}, payload)
+
+    s.Modelo = int(payload[1])
+    s.Firmware = [3]int{int(payload[2]), int(payload[3]), int(payload[4])}
+    s.Octetos5a20 = payload[5:21]
+
+    s.Armado = int((payload[21] >> 5) & 0x03)
+    s.ZonasEmAlarme = (payload[21] & 0x08) != 0
+    s.ZonasCanceladas = (payload[21] & 0x10) != 0
+    s.ZonasFechadas = (payload[21] & 0x04) != 0
+    s.Sirene = (payload[21] & 0x02) != 0
+    s.Problemas = (payload[21] & 0x01) != 0
+
+    s.Particoes = []StatusParticao{}
+    for particao := range 17 {
+        octeto := payload[22 + particao]
+        if (octeto & 0x80) == 0 {
+            // não habilitada
+            continue
+        }
+        s.Particoes = append(s.Particoes, StatusParticao{
+            Numero: particao,
+            Stay: (octeto & 0x40) != 0,
+            DelaySaida: (octeto & 0x20) != 0,
+            ProntoArmar: (octeto & 0x10) != 0,
+            AlarmeOcorreu: (octeto & 0x08) != 0,
+            EmAlarme: (octeto & 0x04) != 0,
+            ArmadoStay: (octeto & 0x02) != 0,
+            Armado: (octeto & 0x01) != 0,
+        })
+    }
+
+    s.ZonasAbertas = BitsParaNumeros(payload[39:47])
+    s.ZonasAlarme = BitsParaNumeros(payload[47:55])
+    // Bits invertidos poderiam significar "zonas ativas"
+    s.ZonasBypass = BitsParaNumeros(payload[55:63])
+    s.Sirenes = BitsParaNumeros(payload[63:65])
+    s.Octetos65 = payload[65:]
+
+    return s, nil
+}
+
 type PacoteRIP struct {
     Longo bool
     Tipo int
@@ -354,34 +572,49 @@ func ExtrairFrameRIP(buffer []byte) (PacoteRIP, int) {
     return PacoteRIP{true, tipo, msg}, esperado
 }
 
-func ParseRIPIdentificacaoCentral(pacote PacoteRIP) (int, string, bool, string) {
+// Descrição do canal de comunicação informado na identificação da central
+func CanalIdentificacao(canal byte) string {
+    switch canal {
+    case 'E':
+        return "Ethernet"
+    case 'G':
+        return "GPRS"
+    case 'H':
+        return "GPRS2"
+    }
+    return fmt.Sprintf("%02x", canal)
+}
+
+// Retorna conta, MAC, canal, sucesso e mensagem de erro
+func ParseRIPIdentificacaoCentral(pacote PacoteRIP) (int, string, string, bool, string) {
     if len(pacote.Payload) != 7 {
         msg := fmt.Sprintf("ParseRIPIdentificacaoCentral: tamanho inesperado %s", HexPrint(pacote.Payload))
-        return 0, "", false, msg
+        return 0, "", "", false, msg
     }
 
-    // canal := msg[0] // 'E' (0x45)=Ethernet, 'G'=GPRS, 'H'=GPRS2
+    canal := CanalIdentificacao(pacote.Payload[0])
     conta, _ := FromBCD(pacote.Payload[1:3])
-    macaddr := HexPrint(pacote.Payload[3:6])
+    // formato aa:bb:cc, minúsculo, como esperado pela config "centrais"
+    macaddr := strings.ReplaceAll(HexPrint(pacote.Payload[3:6]), " ", ":")
 
-    return conta, macaddr, true, ""
+    return conta, macaddr, canal, true, ""
 }
 
 type RIPAlarme struct {
-    Valido bool
-    Erro string
-    Canal int
-    ContactId int
-    Tipo int
-    Qualificador int
-    Codigo int
-    Particao int
-    Zona int
-    IndiceFotos int
-    NrFotos int
-    CodigoConhecido bool
-    DescricaoHumana string
-}      
+    Valido bool `json:"valido"`
+    Erro string `json:"erro,omitempty"`
+    Canal int `json:"canal"`
+    ContactId int `json:"contact_id"`
+    Tipo int `json:"tipo"`
+    Qualificador int `json:"qualificador"`
+    Codigo int `json:"codigo"`
+    Particao int `json:"particao"`
+    Zona int `json:"zona"`
+    IndiceFotos int `json:"indice_fotos,omitempty"`
+    NrFotos int `json:"nr_fotos,omitempty"`
+    CodigoConhecido bool `json:"codigo_conhecido"`
+    DescricaoHumana string `json:"descricao,omitempty"`
+}
 
 func ParseRIPAlarme(pacote PacoteRIP, com_foto bool) RIPAlarme {
     res := RIPAlarme{}
@@ -3,6 +3,9 @@ package goalarmeitbl
 import (
     "testing"
     "bytes"
+    "encoding/hex"
+    "slices"
+    "strings"
 )
 
 func TestChecksum(t *testing.T) {
@@ -193,3 +196,105 @@ func TestPacoteIsecNet2Parse(t *testing.T) {
         return
     }
 }
+
+func TestParseRIPIdentificacaoCentral(t *testing.T) {
+    pacote := PacoteRIP{true, 0x94, []byte{0x45, 0x12, 0x34, 0xaa, 0xbb, 0x0c, 0x00}}
+    conta, macaddr, canal, ok, _ := ParseRIPIdentificacaoCentral(pacote)
+    if !ok || conta != 1234 || macaddr != "aa:bb:0c" || canal != "Ethernet" {
+        t.Errorf("failed I %d %s %s", conta, macaddr, canal)
+    }
+
+    pacote.Payload = pacote.Payload[:6]
+    _, _, _, ok, _ = ParseRIPIdentificacaoCentral(pacote)
+    if ok {
+        t.Errorf("failed II")
+    }
+}
+
+// Os payloads de status abaixo são SINTÉTICOS, montados à mão a partir da nossa leitura do formato
+// (a mesma da versão Python, alarmeitbl/comandos.py), e não capturados de uma central real.
+// Provam apenas que o parser segue essa leitura. Não há captura real disponível no repositório;
+// ao obter uma (LOGITBL=1 gocomandar ... status), incluí-la aqui como statusPayloadCapturado.
+
+// Sintético: reconstruído da saída de uma AMT-8000 real exemplificada em Go.md (firmware 2.3.1,
+// partição 1 armada); apenas os campos mostrados naquela saída são de fato conferidos
+const statusPayloadReadme = "01 02 03 01 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 " +
+    "24 90 91 90 00 00 00 00 00 00 00 00 00 00 00 00 00 00 " +
+    "00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 " +
+    "00 00 00 00 00 00 00 00"
+
+// Sintético: zonas abertas, em alarme e em bypass, sirene ligada
+const statusPayloadZonas = "01 02 03 01 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 " +
+    "6b 80 8d 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 " +
+    "05 01 00 00 00 00 00 00 04 00 00 00 00 00 00 00 00 80 00 00 00 00 00 00 01 00 " +
+    "aa bb"
+
+func hexpayload(s string) []byte {
+    dados, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+    if err != nil {
+        panic(err)
+    }
+    return dados
+}
+
+func TestParseStatusCentral(t *testing.T) {
+    s, err := ParseStatusCentral(hexpayload(statusPayloadReadme))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if s.Modelo != 1 || s.Firmware != [3]int{2, 3, 1} || s.Armado != 0x01 {
+        t.Errorf("failed I %v", s)
+    }
+    if !s.ZonasFechadas || s.ZonasEmAlarme || s.Sirene || s.Problemas || s.ZonasCanceladas {
+        t.Errorf("failed II %v", s)
+    }
+    if len(s.Particoes) != 3 || !s.Particoes[1].Armado || s.Particoes[0].Armado || !s.Particoes[2].ProntoArmar {
+        t.Errorf("failed III %v", s.Particoes)
+    }
+    if len(s.ZonasAbertas) != 0 || len(s.ZonasAlarme) != 0 || len(s.ZonasBypass) != 0 || len(s.Sirenes) != 0 {
+        t.Errorf("failed IV %v", s)
+    }
+
+    s, err = ParseStatusCentral(hexpayload(statusPayloadZonas))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if s.Armado != 0x03 || !s.ZonasEmAlarme || !s.Sirene || !s.Problemas || s.ZonasFechadas {
+        t.Errorf("failed V %v", s)
+    }
+    if len(s.Particoes) != 2 || s.Particoes[1].Numero != 1 || !s.Particoes[1].EmAlarme || !s.Particoes[1].AlarmeOcorreu {
+        t.Errorf("failed VI %v", s.Particoes)
+    }
+    if !slices.Equal(s.ZonasAbertas, []int{1, 3, 9}) || !slices.Equal(s.ZonasAlarme, []int{3}) ||
+            !slices.Equal(s.ZonasBypass, []int{16}) || !slices.Equal(s.Sirenes, []int{1}) {
+        t.Errorf("failed VII %v", s)
+    }
+    if !bytes.Equal(s.Octetos65, []byte{0xaa, 0xbb}) || len(s.Octetos5a20) != 16 {
+        t.Errorf("failed VIII %v", s)
+    }
+
+    _, err = ParseStatusCentral(hexpayload(statusPayloadZonas)[:63])
+    if err == nil {
+        t.Errorf("failed IX")
+    }
+}
+
+func TestNakReason(t *testing.T) {
+    if NakFotoNaoGravada.String() != "ainda gravando foto" || !NakFotoNaoGravada.Repetivel() {
+        t.Error("NAK 0x28 should be retryable")
+    }
+    if NakSemFoto.Repetivel() || NakReason(0x1e).Repetivel() {
+        t.Error("NAK 0x23/0x1e should be permanent")
+    }
+    if NakReason(0x01).Repetivel() || NakReason(0x02).Repetivel() {
+        t.Error("NAK 0x01/0x02 (encoding errors) should be permanent")
+    }
+    if NakReason(0x99).String() != "desconhecido" || NakReason(0x99).Repetivel() {
+        t.Error("Unknown NAK should be permanent")
+    }
+    for motivo := NakReason(0x00); motivo <= 0x38; motivo++ {
+        if motivo.String() == "desconhecido" {
+            t.Errorf("NAK %02x missing from table", int(motivo))
+        }
+    }
+}
@@ -2,8 +2,10 @@ package goalarmeitbl
 
 import (
     "fmt"
+    "net"
+    "net/http"
     "time"
-    "os/exec"
+    "slices"
     "sync"
 )
 
@@ -13,22 +15,111 @@ type ReceptorIP struct {
     wg sync.WaitGroup
     centrais_conectadas int
     cnc_alarme bool
+    fotos *TratadorFotos
+    journal *Journal // nil = desabilitado
+    mqtt *PublicadorMQTT // nil = desabilitado
+    controle *http.Server // nil = desabilitado
+    controle_addr net.Addr
+    ganchos *DespachanteGanchos
+    inicio time.Time
+    ausentes map[string]bool // supervisão individual: centrais esperadas consideradas ausentes
+
+    // acessado pelas goroutines dos tratadores
+    mutex sync.Mutex
+    centrais_identificadas int
+    registro map[string]*RegistroCentral // centrais identificadas, por MAC
+    eventos_recentes map[chaveEvento]time.Time // para deduplicação
+    tratadores map[*TratadorReceptorIP]bool // conexões abertas, interrompidas no encerramento
+    wg_tratadores sync.WaitGroup
+}
+
+// Identidade de um evento para fins de deduplicação
+type chaveEvento struct {
+    macaddr string
+    contact_id int
+    codigo int
+    qualificador int
+    particao int
+    zona int
 }
 
 func NewReceptorIP(cfg ReceptorIPConfig) (*ReceptorIP, error) {
     r := new(ReceptorIP)
     r.cfg = cfg
+    r.registro = make(map[string]*RegistroCentral)
+    r.eventos_recentes = make(map[chaveEvento]time.Time)
+    r.tratadores = make(map[*TratadorReceptorIP]bool)
+    r.inicio = time.Now()
+    r.ausentes = make(map[string]bool)
+    consumidores := slices.Clone(cfg.Consumidores)
+    for _, cfg_webhook := range cfg.Webhooks {
+        webhook, err := NewWebhook(cfg_webhook)
+        if err != nil {
+            return r, err
+        }
+        consumidores = append(consumidores, webhook)
+    }
+    if cfg.MQTT.Broker != "" {
+        var executor ExecutorComandoMQTT
+        if cfg.MQTT.Comandos {
+            executor = r.executar_comando_mqtt
+        }
+        r.mqtt = NewPublicadorMQTT(cfg.MQTT, executor)
+        consumidores = append(consumidores, r.mqtt)
+    }
+    r.ganchos = NewDespachanteGanchos(cfg.Ganchos, consumidores, cfg.GanchosFila, cfg.GanchosConcorrencia,
+        cfg.GanchosTimeout, PoliticaRetry{cfg.GanchosTentativas, time.Second, 30 * time.Second},
+        cfg.ConsumidoresTimeout)
     var err error
     r.tcp, err = NewTCPServer(fmt.Sprintf("%s:%d", cfg.Addr, cfg.Port))
     if err != nil {
+        if r.mqtt != nil {
+            r.mqtt.Close()
+        }
         return r, err
     }
     fmt.Println("ReceptorIP: inicio")
 
-    r.wg.Go(func() {
-        r.tcp.Timeout(15 * time.Second, 0, "Watchdog")
+    if cfg.Journal != "" {
+        r.journal, err = NewJournal(cfg.Journal, cfg.JournalTamMax, cfg.JournalArquivos)
+        if err != nil {
+            r.tcp.Close()
+            if r.mqtt != nil {
+                r.mqtt.Close()
+            }
+            return r, err
+        }
+    }
+
+    if cfg.TamSenha > 0 {
+        r.fotos = NewTratadorFotos(r, cfg)
+    }
+
+    if cfg.Controle != "" {
+        if err := r.iniciar_controle(); err != nil {
+            r.tcp.Close()
+            if r.fotos != nil {
+                r.fotos.Close()
+            }
+            if r.journal != nil {
+                r.journal.Close()
+            }
+            if r.mqtt != nil {
+                r.mqtt.Close()
+            }
+            return r, err
+        }
+    }
+
+    // criados antes de retornar, pois o servidor não aceita novos timeouts após Close()
+    r.tcp.Timeout(15 * time.Second, 0, "Watchdog")
+    if len(cfg.CentraisEsperadas) > 0 {
+        r.tcp.Timeout(r.intervalo_supervisao(), 0, "Supervisao")
+    } else {
         r.tcp.Timeout(3600 * time.Second, 0, "Central_nc")
+    }
 
+    r.wg.Go(func() {
         for evt := range r.tcp.Events {
             switch evt.Name {
             case "New":
@@ -42,9 +133,24 @@ func NewReceptorIP(cfg ReceptorIPConfig) (*ReceptorIP, error) {
                 r.Watchdog(evt.Cargo.(*Timeout))
             case "Central_nc":
                 r.CentralNaoConectada(evt.Cargo.(*Timeout))
+            case "Supervisao":
+                r.Supervisao(evt.Cargo.(*Timeout))
             }
         }
 
+        // servidor encerrado: fecha as conexões que ainda usam journal e MQTT
+        r.interromper_tratadores()
+        r.wg_tratadores.Wait()
+
+        if r.fotos != nil {
+            r.fotos.Close()
+        }
+        if r.journal != nil {
+            r.journal.Close()
+        }
+        if r.mqtt != nil {
+            r.mqtt.Close()
+        }
         fmt.Println("ReceptorIP: fim ----")
     })
 
@@ -55,17 +161,116 @@ func (r *ReceptorIP) Wait() {
     r.wg.Wait()
 }
 
-func (r *ReceptorIP) InvocaGancho(tipo string, msg string) {
-    script := r.cfg.Ganchos["gancho_" + tipo]
-    cmd := exec.Command(script, msg)
-    if err := cmd.Run(); err != nil {
-        fmt.Printf("ReceptorIP: script %s %s falhou com erro %v\n", tipo, script, err) 
+// Endereço em que o receptor aguarda as centrais
+func (r *ReceptorIP) Addr() net.Addr {
+    return r.tcp.Addr()
+}
+
+// Endereço da API de controle, nil se desabilitada
+func (r *ReceptorIP) AddrControle() net.Addr {
+    return r.controle_addr
+}
+
+// Encerra o receptor: API de controle, servidor TCP e conexões com as centrais, download de
+// fotos, journal e cliente MQTT. Retorna após o encerramento, quando Wait() também retorna.
+// Ganchos já enfileirados continuam em execução
+func (r *ReceptorIP) Close() {
+    if r.controle != nil {
+        r.controle.Close()
+    }
+    r.tcp.Close()
+    r.wg.Wait()
+}
+
+func (r *ReceptorIP) adiciona_tratador(t *TratadorReceptorIP) {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+    r.tratadores[t] = true
+}
+
+func (r *ReceptorIP) remove_tratador(t *TratadorReceptorIP) {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+    delete(r.tratadores, t)
+}
+
+func (r *ReceptorIP) interromper_tratadores() {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+    for t := range r.tratadores {
+        t.tcp.Abort()
+    }
+}
+
+// Testa se há lugar para mais uma central identificada
+func (r *ReceptorIP) valida_maxconn() bool {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+    return r.centrais_identificadas < r.cfg.MaxConn
+}
+
+// Testa se o evento já foi recebido dentro da janela de deduplicação, e o registra
+// Centrais com certos firmwares abrem conexões paralelas (e.g. para receptores 1 e 2 configurados
+// com o mesmo endereço) e enviam o mesmo evento por todas elas
+// Invocado pelo tratador
+func (r *ReceptorIP) evento_duplicado(macaddr string, evento RIPAlarme) bool {
+    if r.cfg.JanelaDedup <= 0 {
+        return false
+    }
+
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    agora := time.Now()
+    for chave, quando := range r.eventos_recentes {
+        if agora.Sub(quando) > r.cfg.JanelaDedup {
+            delete(r.eventos_recentes, chave)
+        }
     }
+
+    chave := chaveEvento{macaddr, evento.ContactId, evento.Codigo, evento.Qualificador, evento.Particao, evento.Zona}
+    if _, ok := r.eventos_recentes[chave]; ok {
+        return true
+    }
+    r.eventos_recentes[chave] = agora
+    return false
+}
+
+// Remove evento da janela de deduplicação, para que sua retransmissão seja tratada
+// Invocado pelo tratador quando o evento não foi confirmado à central
+func (r *ReceptorIP) esquecer_evento(macaddr string, evento RIPAlarme) {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+    delete(r.eventos_recentes, chaveEvento{macaddr, evento.ContactId, evento.Codigo, evento.Qualificador,
+        evento.Particao, evento.Zona})
+}
+
+// Enfileira gancho do próprio receptor para execução assíncrona
+// Retorna erro apenas se a fila de ganchos está cheia
+func (r *ReceptorIP) InvocaGancho(tipo string, dados DadosGancho, args ...string) error {
+    _, err := r.ganchos.Enfileirar("", tipo, dados, args...)
+    return err
+}
+
+// Enfileira gancho relativo a uma central, executado em ordem com os demais ganchos da mesma central
+func (r *ReceptorIP) invoca_gancho_central(macaddr string, tipo string, dados DadosGancho, args ...string) (chan error, error) {
+    return r.ganchos.Enfileirar(macaddr, tipo, dados, args...)
+}
+
+// Identidade da central para os ganchos, conforme o registro de centrais
+func (r *ReceptorIP) dados_central(macaddr string) DadosGancho {
+    dados := DadosGancho{Mac: macaddr}
+    if reg, ok := r.Central(macaddr); ok {
+        dados.Conta = reg.Conta
+        dados.Canal = reg.Canal
+        dados.Endereco = reg.Endereco
+    }
+    return dados
 }
 
 func (r *ReceptorIP) Watchdog(to *Timeout) {
     fmt.Println("receptor em funcionamento")
-    r.InvocaGancho("watchdog", "")
+    r.InvocaGancho("watchdog", DadosGancho{}, "")
     to.Reset(3600 * time.Second, 0)
 }
 
@@ -74,12 +279,48 @@ func (r *ReceptorIP) CentralNaoConectada(to *Timeout) {
         if !r.cnc_alarme {
             r.cnc_alarme = true
             fmt.Println("nenhuma central conectada")
-            r.InvocaGancho("central", ", 1")
+            r.InvocaGancho("central", DadosGancho{Falha: true}, "1")
         }
     } else {
         if r.cnc_alarme {
             r.cnc_alarme = false
-            r.InvocaGancho("central", ", 0")
+            r.InvocaGancho("central", DadosGancho{}, "0")
+        }
+    }
+    to.Restart()
+}
+
+// Supervisão individual: cada central esperada deve manter contato (conexão,
+// heartbeat ou evento) dentro do prazo configurado
+func (r *ReceptorIP) intervalo_supervisao() time.Duration {
+    return min(r.cfg.PrazoSupervisao / 4, 60 * time.Second)
+}
+
+func (r *ReceptorIP) Supervisao(to *Timeout) {
+    agora := time.Now()
+    for _, mac := range r.cfg.CentraisEsperadas {
+        // se a central nunca foi vista, o prazo conta do início do receptor
+        ultimo := r.inicio
+        args := []string{mac}
+        dados := r.dados_central(mac)
+        reg, ok := r.Central(mac)
+        if ok {
+            args = append(args, fmt.Sprintf("%d", reg.Conta))
+            if reg.UltimoContato.After(ultimo) {
+                ultimo = reg.UltimoContato
+            }
+        }
+        ausente := agora.Sub(ultimo) > r.cfg.PrazoSupervisao
+
+        if ausente && !r.ausentes[mac] {
+            r.ausentes[mac] = true
+            fmt.Printf("central %s sem comunicação desde %s\n", mac, ultimo.Format(time.DateTime))
+            dados.Falha = true
+            r.InvocaGancho("central", dados, slices.Concat([]string{"1"}, args)...)
+        } else if !ausente && r.ausentes[mac] {
+            delete(r.ausentes, mac)
+            fmt.Printf("central %s voltou a se comunicar\n", mac)
+            r.InvocaGancho("central", dados, slices.Concat([]string{"0"}, args)...)
         }
     }
     to.Restart()
@@ -0,0 +1,139 @@
+package goalarmeitbl
+
+import (
+    "testing"
+    "net"
+    "os"
+    "path/filepath"
+    "slices"
+    "strings"
+    "time"
+)
+
+// Funções auxiliares dos testes do Receptor IP
+
+// Configuração de Receptor IP para testes, em localhost. A porta é escolhida pelo sistema
+// (ver ReceptorIP.Addr), para que os testes não disputem portas fixas
+func configteste(t *testing.T, cfg_extra string) ReceptorIPConfig {
+    f := strings.NewReader("[receptorip]\naddr = 127.0.0.1\nport = 9010\n" + cfg_extra)
+    cfg, err := NewReceptorIPConfig(f)
+    if err != nil {
+        t.Fatal(err)
+    }
+    cfg.Port = 0
+    return cfg
+}
+
+// Receptor IP para testes, encerrado ao fim do teste
+func receptorteste_cfg(t *testing.T, cfg ReceptorIPConfig) *ReceptorIP {
+    r, err := NewReceptorIP(cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(r.Close)
+    return r
+}
+
+func receptorteste(t *testing.T, cfg_extra string) *ReceptorIP {
+    return receptorteste_cfg(t, configteste(t, cfg_extra))
+}
+
+// Gancho que registra os parâmetros recebidos num arquivo, uma linha por invocação
+func ganchoteste(t *testing.T) (string, string) {
+    dir := t.TempDir()
+    saida := filepath.Join(dir, "saida.txt")
+    script := filepath.Join(dir, "gancho")
+    conteudo := "#!/bin/sh\necho \"$@\" >> " + saida + "\n"
+    if err := os.WriteFile(script, []byte(conteudo), 0755); err != nil {
+        t.Fatal(err)
+    }
+    return script, saida
+}
+
+func aguardalinhas(t *testing.T, arquivo string, n int) []string {
+    var linhas []string
+    for range 200 {
+        dados, _ := os.ReadFile(arquivo)
+        linhas = strings.Split(strings.TrimSpace(string(dados)), "\n")
+        if len(dados) > 0 && len(linhas) >= n {
+            return linhas
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    t.Fatalf("Expected %d lines, got %v", n, linhas)
+    return nil
+}
+
+// Pacote de evento de alarme 0xb0 (Contact ID), como enviado pela central
+func pacoteevento(codigo int, particao int, zona int) []byte {
+    payload := slices.Concat([]byte{0xb0, 0x11}, ContactIDEncode(1234, 4), ContactIDEncode(18, 2), []byte{1},
+        ContactIDEncode(codigo, 3), ContactIDEncode(particao, 2), ContactIDEncode(zona, 3))
+    return PacoteRIP{true, 0xb0, payload}.Encode()
+}
+
+// Aguarda condição sobre o registro de uma central
+func aguardaregistro(t *testing.T, r *ReceptorIP, mac string, cond func(RegistroCentral) bool) RegistroCentral {
+    var reg RegistroCentral
+    for range 100 {
+        reg, _ = r.Central(mac)
+        if cond(reg) {
+            return reg
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    t.Fatalf("Condition not met, registry %+v", reg)
+    return reg
+}
+
+// Conexão de central com o receptor, fechada ao fim do teste
+func conectarteste(t *testing.T, r *ReceptorIP) net.Conn {
+    c, err := net.Dial("tcp", r.Addr().String())
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { c.Close() })
+    return c
+}
+
+// Central simulada, conectada ao receptor, que responde comandos ISECNet2 multiplexados
+func centralfake_receptor(t *testing.T, r *ReceptorIP, mac []byte, tratador TratadorCentralFake) net.Conn {
+    c := conectarteste(t, r)
+
+    ident := PacoteRIP{true, 0x94, slices.Concat([]byte{0x94, 0x45, 0x12, 0x34}, mac)}
+    c.Write(ident.Encode())
+
+    go func() {
+        buffer := []byte{}
+        tmp := make([]byte, 4096)
+        for {
+            n, err := c.Read(tmp)
+            if err != nil {
+                return
+            }
+            buffer = slices.Concat(buffer, tmp[:n])
+            for len(buffer) > 0 {
+                if buffer[0] == 0xfe {
+                    // resposta genérica do receptor
+                    buffer = buffer[1:]
+                    continue
+                }
+                comprimento := PacoteIsecNet2Completo(buffer)
+                if comprimento == 0 {
+                    break
+                }
+                cmd, payload := PacoteIsecNet2Parse(buffer[:comprimento])
+                buffer = buffer[comprimento:]
+
+                rcmd, rpayload := tratador(cmd, payload)
+                if rcmd != 0 {
+                    c.Write(PacoteIsecNet2(rcmd, rpayload))
+                } else if cmd == 0xf0f0 {
+                    c.Write(PacoteIsecNet2(0xf0f0, []byte{0x00}))
+                }
+                // despedida: a conexão com o receptor continua aberta
+            }
+        }
+    }()
+
+    return c
+}
@@ -4,20 +4,96 @@ import (
     "fmt"
     "errors"
     "io"
+    "net"
+    "os"
+    "os/exec"
+    "regexp"
+    "strings"
+    "time"
     "github.com/bigkevmcd/go-configparser"
 )
 
 type ReceptorIPConfig struct {
-    Ganchos map[string]string
+    Ganchos map[string][]string // scripts por gancho, e.g. "gancho_ev"; todos opcionais
     Addr string
     Port int
     LogLevel string
+    Centrais *regexp.Regexp // centrais autorizadas, testado contra MAC no formato aa:bb:cc
+    MaxConn int             // número máximo de centrais identificadas simultâneas
+    // Prazo após o qual uma conexão ignorada (central não autorizada ou além de MaxConn)
+    // é fechada; não configurável em arquivo
+    CarenciaIgnorada time.Duration
+
+    // Download de fotos
+    Caddr string            // "auto" = endereço de origem da conexão da central, "receptor" = túnel ISECNet2
+    Cport int
+    Senha int
+    TamSenha int            // 0 = download de fotos desabilitado
+    FolderDlFoto string
+
+    // API de controle (HTTP), "" = desabilitada
+    Controle string
+    ControleToken string // exigido em "Authorization: Bearer <token>", "" = sem autenticação (apenas localhost)
+
+    // Supervisão individual de centrais. Se vazio, supervisão global (nenhuma central conectada)
+    CentraisEsperadas []string
+    PrazoSupervisao time.Duration // tempo sem contato para considerar a central ausente
+
+    JanelaDedup time.Duration // eventos repetidos nesta janela são ignorados, 0 = desabilitado
+
+    // Journal persistente de frames e eventos, "" = desabilitado
+    Journal string
+    JournalTamMax int64  // tamanho em bytes a partir do qual o arquivo é rotacionado
+    JournalArquivos int  // número de arquivos rotacionados mantidos
+
+    // Quando confirmar eventos à central: "imediata", ou "journal"/"gancho" (at-least-once),
+    // apenas após o evento ser gravado no journal ou gancho_ev terminar com sucesso
+    Confirmacao string
+
+    // Execução assíncrona dos ganchos
+    GanchosFila int               // execuções pendentes, além disso os ganchos são descartados
+    GanchosConcorrencia int       // execuções simultâneas de um mesmo gancho
+    GanchosTimeout time.Duration  // prazo por execução, após o qual o script é encerrado
+    GanchosTentativas int         // tentativas se o script terminar com status diferente de zero
+    ConsumidoresTimeout time.Duration // prazo de cada consumidor (webhook, MQTT...) por gancho
+
+    // Consumidores de eventos em processo, para uso como biblioteca (não configurável em arquivo)
+    Consumidores []ConsumidorEventos
+
+    // Seções [webhook <nome>]
+    Webhooks []ConfigWebhook
+
+    // Seção [mqtt], Broker "" = desabilitado
+    MQTT ConfigMQTT
+}
+
+// Avisos de configuração vão para stderr, para não se misturar à saída de subcomandos como "journal"
+func aviso(formato string, args ...any) {
+    fmt.Fprintf(os.Stderr, "Aviso: " + formato + "\n", args...)
 }
 
 func NewReceptorIPConfig(in io.Reader) (ReceptorIPConfig, error) {
     sec := "receptorip"
-    ganchos := []string{"gancho_central", "gancho_ev", "gancho_msg", "gancho_watchdog"}
-    c := ReceptorIPConfig{make(map[string]string), "", 9010, ""}
+    ganchos := []string{"gancho_arquivo", "gancho_central", "gancho_ev", "gancho_msg", "gancho_watchdog"}
+    c := ReceptorIPConfig{
+        Ganchos: make(map[string][]string),
+        Port: 9010,
+        MaxConn: 999,
+        CarenciaIgnorada: 60 * time.Second,
+        Caddr: "auto",
+        Cport: 9009,
+        FolderDlFoto: ".",
+        PrazoSupervisao: 1800 * time.Second,
+        JournalTamMax: 10 * 1024 * 1024,
+        JournalArquivos: 5,
+        Confirmacao: "imediata",
+        GanchosFila: 1000,
+        GanchosConcorrencia: 4,
+        GanchosTimeout: 30 * time.Second,
+        GanchosTentativas: 3,
+        ConsumidoresTimeout: 60 * time.Second,
+        MQTT: ConfigMQTT{Prefixo: "alarmeitbl", Discovery: "homeassistant"},
+    }
 
     p, err := configparser.ParseReaderWithOptions(in)
     if err != nil {
@@ -31,23 +107,23 @@ func NewReceptorIPConfig(in io.Reader) (ReceptorIPConfig, error) {
     addr, err := p.Get(sec, "addr")
     if err == nil {
         if addr == "0.0.0.0" || addr == "::" {
-            fmt.Println("Aviso: addr coringa, ouvindo em todas as interfaces")
+            aviso("addr coringa, ouvindo em todas as interfaces")
             addr = ""
         }
         c.Addr = addr
     } else {
-        fmt.Println("Aviso: addr não especificado, ouvindo em todas as interfaces")
+        aviso("addr não especificado, ouvindo em todas as interfaces")
     }
 
     port, err := p.GetInt64(sec, "port")
     if err == nil {
         if port <= 0 || port >= 65536 {
-            fmt.Printf("Aviso: port com valor inválido, ouvindo na porta default %d\n", c.Port)
+            aviso("port com valor inválido, ouvindo na porta default %d", c.Port)
         } else {
             c.Port = int(port)
         }
     } else {
-        fmt.Printf("Aviso: port não especificado, ouvindo na porta default %d\n", c.Port)
+        aviso("port não especificado, ouvindo na porta default %d", c.Port)
     }
 
     loglevel, err := p.Get(sec, "loglevel")
@@ -55,13 +131,358 @@ func NewReceptorIPConfig(in io.Reader) (ReceptorIPConfig, error) {
         c.LogLevel = loglevel
     }
 
+    // Ancorado no início, como o re.match() da versão Python
+    centrais := ".*"
+    centrais_cfg, err := p.Get(sec, "centrais")
+    if err == nil {
+        centrais = centrais_cfg
+    } else {
+        aviso("centrais não especificado, aceitando qualquer central")
+    }
+    c.Centrais, err = regexp.Compile("^(?:" + centrais + ")")
+    if err != nil {
+        return c, errors.New(fmt.Sprintf("centrais: expressão regular inválida: %v", err))
+    }
+
+    if tem, _ := p.HasOption(sec, "maxconn"); tem {
+        maxconn, err := p.GetInt64(sec, "maxconn")
+        if err != nil || maxconn <= 0 {
+            valor, _ := p.Get(sec, "maxconn")
+            return c, errors.New(fmt.Sprintf("maxconn: valor inválido %s", valor))
+        }
+        c.MaxConn = int(maxconn)
+    }
+
+    caddr, err := p.Get(sec, "caddr")
+    if err == nil {
+        c.Caddr = strings.ToLower(strings.TrimSpace(caddr))
+    }
+
+    cport, err := p.GetInt64(sec, "cport")
+    if err == nil {
+        if cport <= 0 || cport >= 65536 {
+            aviso("cport com valor inválido, usando default %d", c.Cport)
+        } else {
+            c.Cport = int(cport)
+        }
+    }
+
+    senha, err := p.GetInt64(sec, "senha")
+    if err == nil {
+        tamanho, err := p.GetInt64(sec, "tamanho")
+        if err == nil && (tamanho == 4 || tamanho == 6) {
+            c.Senha = int(senha)
+            c.TamSenha = int(tamanho)
+        } else {
+            aviso("tamanho de senha inválido, download de fotos desabilitado")
+        }
+    } else {
+        aviso("senha não especificada, download de fotos desabilitado")
+    }
+
+    folder_dlfoto, err := p.Get(sec, "folder_dlfoto")
+    if err == nil {
+        c.FolderDlFoto = folder_dlfoto
+    }
+
+    controle_token, err := p.Get(sec, "controle_token")
+    if err == nil {
+        c.ControleToken = strings.TrimSpace(controle_token)
+    }
+
+    controle, err := p.Get(sec, "controle")
+    if err == nil {
+        c.Controle = strings.TrimSpace(controle)
+        host, _, err := net.SplitHostPort(c.Controle)
+        if err != nil {
+            return c, errors.New(fmt.Sprintf("controle: endereço inválido: %v", err))
+        }
+        // a API pode desarmar a central, portanto só é exposta fora do host local com autenticação
+        if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+            if c.ControleToken == "" {
+                return c, errors.New("controle: API acessível fora do host local requer controle_token")
+            }
+            aviso("API de controle acessível fora do host local")
+        }
+    }
+
+    esperadas, err := p.Get(sec, "centrais_esperadas")
+    if err == nil {
+        formato := regexp.MustCompile("^[0-9a-f]{2}:[0-9a-f]{2}:[0-9a-f]{2}$")
+        for _, mac := range strings.FieldsFunc(strings.ToLower(esperadas), func(r rune) bool {
+                return r == ',' || r == ' ' }) {
+            if !formato.MatchString(mac) {
+                return c, errors.New(fmt.Sprintf("centrais_esperadas: MAC inválido %s", mac))
+            }
+            c.CentraisEsperadas = append(c.CentraisEsperadas, mac)
+        }
+    }
+
+    prazo, err := p.GetFloat64(sec, "prazo_supervisao")
+    if err == nil {
+        if prazo <= 0 {
+            aviso("prazo_supervisao com valor inválido, usando default %v", c.PrazoSupervisao)
+        } else {
+            c.PrazoSupervisao = time.Duration(prazo * float64(time.Second))
+        }
+    }
+
+    janela_dedup, err := p.GetFloat64(sec, "janela_dedup")
+    if err == nil {
+        if janela_dedup < 0 {
+            aviso("janela_dedup com valor inválido, deduplicação desabilitada")
+        } else {
+            c.JanelaDedup = time.Duration(janela_dedup * float64(time.Second))
+        }
+    }
+
+    journal, err := p.Get(sec, "journal")
+    if err == nil {
+        c.Journal = strings.TrimSpace(journal)
+    }
+
+    journal_tam_max, err := p.GetFloat64(sec, "journal_tam_max")
+    if err == nil {
+        if journal_tam_max <= 0 {
+            aviso("journal_tam_max com valor inválido, usando default %d MB", c.JournalTamMax / 1024 / 1024)
+        } else {
+            c.JournalTamMax = int64(journal_tam_max * 1024 * 1024)
+        }
+    }
+
+    journal_arquivos, err := p.GetInt64(sec, "journal_arquivos")
+    if err == nil {
+        if journal_arquivos < 0 {
+            aviso("journal_arquivos com valor inválido, usando default %d", c.JournalArquivos)
+        } else {
+            c.JournalArquivos = int(journal_arquivos)
+        }
+    }
+
+    confirmacao, err := p.Get(sec, "confirmacao")
+    if err == nil {
+        c.Confirmacao = strings.ToLower(strings.TrimSpace(confirmacao))
+        switch c.Confirmacao {
+        case "imediata", "gancho":
+        case "journal":
+            if c.Journal == "" {
+                return c, errors.New("confirmacao = journal requer journal configurado")
+            }
+        default:
+            return c, errors.New(fmt.Sprintf("confirmacao: valor inválido %s", c.Confirmacao))
+        }
+    }
+
+    ganchos_fila, err := p.GetInt64(sec, "ganchos_fila")
+    if err == nil {
+        if ganchos_fila <= 0 {
+            aviso("ganchos_fila com valor inválido, usando default %d", c.GanchosFila)
+        } else {
+            c.GanchosFila = int(ganchos_fila)
+        }
+    }
+
+    ganchos_concorrencia, err := p.GetInt64(sec, "ganchos_concorrencia")
+    if err == nil {
+        if ganchos_concorrencia <= 0 {
+            aviso("ganchos_concorrencia com valor inválido, usando default %d", c.GanchosConcorrencia)
+        } else {
+            c.GanchosConcorrencia = int(ganchos_concorrencia)
+        }
+    }
+
+    ganchos_timeout, err := p.GetFloat64(sec, "ganchos_timeout")
+    if err == nil {
+        if ganchos_timeout <= 0 {
+            aviso("ganchos_timeout com valor inválido, usando default %v", c.GanchosTimeout)
+        } else {
+            c.GanchosTimeout = time.Duration(ganchos_timeout * float64(time.Second))
+        }
+    }
+
+    ganchos_tentativas, err := p.GetInt64(sec, "ganchos_tentativas")
+    if err == nil {
+        if ganchos_tentativas <= 0 {
+            aviso("ganchos_tentativas com valor inválido, usando default %d", c.GanchosTentativas)
+        } else {
+            c.GanchosTentativas = int(ganchos_tentativas)
+        }
+    }
+
+    consumidores_timeout, err := p.GetFloat64(sec, "consumidores_timeout")
+    if err == nil {
+        if consumidores_timeout <= 0 {
+            aviso("consumidores_timeout com valor inválido, usando default %v", c.ConsumidoresTimeout)
+        } else {
+            c.ConsumidoresTimeout = time.Duration(consumidores_timeout * float64(time.Second))
+        }
+    }
+
+    // Todos opcionais; vários scripts podem ser separados por vírgula
     for _, gancho := range ganchos {
-        script, err := p.Get(sec, gancho)
+        scripts, err := p.Get(sec, gancho)
         if err != nil {
-            return c, errors.New(fmt.Sprintf("%s não encontrado na config", gancho))
+            continue
+        }
+        for _, script := range strings.Split(scripts, ",") {
+            script = strings.TrimSpace(script)
+            if script == "" {
+                continue
+            }
+            if _, err := exec.LookPath(script); err != nil {
+                aviso("%s: script %s inexistente ou não executável: %v", gancho, script, err)
+            }
+            c.Ganchos[gancho] = append(c.Ganchos[gancho], script)
+        }
+    }
+
+    for _, secao := range p.Sections() {
+        if secao != "webhook" && !strings.HasPrefix(secao, "webhook ") {
+            continue
+        }
+        webhook, err := config_webhook(p, secao)
+        if err != nil {
+            return c, errors.New(fmt.Sprintf("[%s]: %v", secao, err))
+        }
+        c.Webhooks = append(c.Webhooks, webhook)
+    }
+
+    if p.HasSection("mqtt") {
+        c.MQTT, err = config_mqtt(p, "mqtt", c.MQTT)
+        if err != nil {
+            return c, errors.New(fmt.Sprintf("[mqtt]: %v", err))
         }
-        c.Ganchos[gancho] = script
     }
 
     return c, nil
 }
+
+func config_webhook(p *configparser.ConfigParser, sec string) (ConfigWebhook, error) {
+    nome := strings.TrimSpace(strings.TrimPrefix(sec, "webhook"))
+    if nome == "" {
+        nome = "webhook"
+    }
+    w := ConfigWebhook{
+        Nome: nome,
+        Metodo: "POST",
+        Cabecalhos: make(map[string]string),
+        Timeout: 10 * time.Second,
+        Tentativas: 3,
+        Intervalo: time.Second,
+    }
+
+    url, err := p.Get(sec, "url")
+    if err != nil || strings.TrimSpace(url) == "" {
+        return w, errors.New("url não especificada")
+    }
+    w.URL = strings.TrimSpace(url)
+
+    tipos, err := p.Get(sec, "tipos")
+    if err == nil {
+        for _, tipo := range strings.FieldsFunc(strings.ToLower(tipos), func(r rune) bool {
+                return r == ',' || r == ' ' }) {
+            switch tipo {
+            case "central", "ev", "msg", "watchdog", "arquivo":
+                w.Tipos = append(w.Tipos, tipo)
+            default:
+                return w, errors.New(fmt.Sprintf("tipo de gancho desconhecido %s", tipo))
+            }
+        }
+    }
+
+    metodo, err := p.Get(sec, "metodo")
+    if err == nil {
+        w.Metodo = strings.ToUpper(strings.TrimSpace(metodo))
+    }
+
+    opcoes, _ := p.Options(sec)
+    for _, opcao := range opcoes {
+        if nome, ok := strings.CutPrefix(opcao, "cabecalho_"); ok {
+            valor, _ := p.Get(sec, opcao)
+            w.Cabecalhos[nome] = strings.TrimSpace(valor)
+        }
+    }
+
+    corpo, err := p.Get(sec, "corpo")
+    if err == nil {
+        w.Corpo = corpo
+        if _, err := compilar_corpo_webhook(w.Nome, w.Corpo); err != nil {
+            return w, errors.New(fmt.Sprintf("corpo: template inválido: %v", err))
+        }
+    }
+
+    timeout, err := p.GetFloat64(sec, "timeout")
+    if err == nil && timeout > 0 {
+        w.Timeout = time.Duration(timeout * float64(time.Second))
+    }
+
+    tentativas, err := p.GetInt64(sec, "tentativas")
+    if err == nil && tentativas > 0 {
+        w.Tentativas = int(tentativas)
+    }
+
+    intervalo, err := p.GetFloat64(sec, "intervalo")
+    if err == nil && intervalo >= 0 {
+        w.Intervalo = time.Duration(intervalo * float64(time.Second))
+    }
+
+    segredo, err := p.Get(sec, "segredo")
+    if err == nil {
+        w.Segredo = strings.TrimSpace(segredo)
+    }
+
+    arquivo_falhas, err := p.Get(sec, "arquivo_falhas")
+    if err == nil {
+        w.ArquivoFalhas = strings.TrimSpace(arquivo_falhas)
+    }
+
+    return w, nil
+}
+
+func config_mqtt(p *configparser.ConfigParser, sec string, m ConfigMQTT) (ConfigMQTT, error) {
+    broker, err := p.Get(sec, "broker")
+    if err != nil || strings.TrimSpace(broker) == "" {
+        return m, errors.New("broker não especificado")
+    }
+    m.Broker = strings.TrimSpace(broker)
+
+    usuario, err := p.Get(sec, "usuario")
+    if err == nil {
+        m.Usuario = strings.TrimSpace(usuario)
+    }
+
+    senha, err := p.Get(sec, "senha")
+    if err == nil {
+        m.Senha = strings.TrimSpace(senha)
+    }
+
+    cliente, err := p.Get(sec, "cliente")
+    if err == nil {
+        m.Cliente = strings.TrimSpace(cliente)
+    }
+
+    prefixo, err := p.Get(sec, "prefixo")
+    if err == nil {
+        prefixo = strings.Trim(strings.TrimSpace(prefixo), "/")
+        if prefixo == "" || strings.ContainsAny(prefixo, "+#") {
+            return m, errors.New(fmt.Sprintf("prefixo inválido %s", prefixo))
+        }
+        m.Prefixo = prefixo
+    }
+
+    // vazio = sem discovery
+    discovery, err := p.Get(sec, "discovery")
+    if err == nil {
+        m.Discovery = strings.Trim(strings.TrimSpace(discovery), "/")
+    }
+
+    // comandos vindos do broker podem desarmar a central, portanto desabilitados por default
+    if tem, _ := p.HasOption(sec, "comandos"); tem {
+        m.Comandos, err = p.GetBool(sec, "comandos")
+        if err != nil {
+            return m, errors.New(fmt.Sprintf("comandos: valor inválido: %v", err))
+        }
+    }
+
+    return m, nil
+}
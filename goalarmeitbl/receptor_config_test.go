@@ -12,3 +12,66 @@ func TestConfig(t *testing.T) {
         t.Error("Should have failed")
     }   
 }
+
+func TestConfigCentrais(t *testing.T) {
+    f := strings.NewReader("[receptorip]\ncentrais = aa:bb:.*\n" +
+        "gancho_central = x\ngancho_ev = x\ngancho_msg = x\ngancho_watchdog = x\n")
+    cfg, err := NewReceptorIPConfig(f)
+    if err != nil {
+        t.Fatal("Should not have failed", err)
+    }
+    if !cfg.Centrais.MatchString("aa:bb:cc") {
+        t.Error("Should have matched")
+    }
+    if cfg.Centrais.MatchString("00:aa:bb") {
+        t.Error("Should be anchored")
+    }
+
+    f = strings.NewReader("[receptorip]\ncentrais = (\n" +
+        "gancho_central = x\ngancho_ev = x\ngancho_msg = x\ngancho_watchdog = x\n")
+    _, err = NewReceptorIPConfig(f)
+    if err == nil {
+        t.Error("Should have failed")
+    }
+}
+
+func TestConfigMaxConn(t *testing.T) {
+    f := strings.NewReader("[receptorip]\nmaxconn = 2\n" +
+        "gancho_central = x\ngancho_ev = x\ngancho_msg = x\ngancho_watchdog = x\n")
+    cfg, err := NewReceptorIPConfig(f)
+    if err != nil || cfg.MaxConn != 2 {
+        t.Error("Should have parsed maxconn", err, cfg.MaxConn)
+    }
+
+    for _, invalido := range []string{"0", "-1", "x"} {
+        f = strings.NewReader("[receptorip]\nmaxconn = " + invalido + "\n")
+        if _, err = NewReceptorIPConfig(f); err == nil {
+            t.Error("Should have rejected maxconn", invalido)
+        }
+    }
+
+    cfg, err = NewReceptorIPConfig(strings.NewReader("[receptorip]\n"))
+    if err != nil || cfg.MaxConn != 999 {
+        t.Error("Should have used default maxconn", err, cfg.MaxConn)
+    }
+}
+
+func TestConfigGanchos(t *testing.T) {
+    // ganchos opcionais
+    cfg, err := NewReceptorIPConfig(strings.NewReader("[receptorip]\n"))
+    if err != nil || len(cfg.Ganchos) != 0 {
+        t.Error("Hooks should be optional", err, cfg.Ganchos)
+    }
+
+    cfg, err = NewReceptorIPConfig(strings.NewReader("[receptorip]\ngancho_ev = true, ./inexistente\n" +
+        "gancho_msg =\n"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(cfg.Ganchos["gancho_ev"]) != 2 || cfg.Ganchos["gancho_ev"][1] != "./inexistente" {
+        t.Errorf("Unexpected hooks %v", cfg.Ganchos)
+    }
+    if len(cfg.Ganchos["gancho_msg"]) != 0 {
+        t.Errorf("Empty hook should be disabled %v", cfg.Ganchos)
+    }
+}
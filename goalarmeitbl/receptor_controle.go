@@ -0,0 +1,194 @@
+package goalarmeitbl
+
+import (
+    "context"
+    "crypto/subtle"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "mime"
+    "net"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// API de controle do Receptor IP, via HTTP (normalmente em localhost)
+// Se houver token configurado, toda requisição deve trazer o cabeçalho "Authorization: Bearer <token>";
+// caso contrário, apenas requisições com cabeçalho Host de loopback são aceitas (contra DNS rebinding)
+//
+// GET /centrais: lista as centrais registradas, conectadas ou não
+// GET /comandos: lista os comandos disponíveis
+// POST /centrais/{mac}/comando: executa comando(s) na central, através da
+//   conexão existente com o receptor (túnel ISECNet2). Corpo JSON:
+//   {"comando": "desativar 1; status", "senha": 123456, "tamanho": 6, "tentativas": 1}
+//   Content-Type deve ser application/json (uma página web não envia tal requisição sem CORS).
+//   Senha e tamanho são opcionais se configurados no receptor. Tentativas é opcional, default 1.
+//   O comando foto grava sempre na pasta configurada no receptor (folder_dlfoto).
+//   Responde com o RelatorioComando em JSON.
+
+// Requisição de comando via API de controle
+type RequisicaoComando struct {
+    Comando string `json:"comando"`
+    Senha int `json:"senha,omitempty"`
+    Tamanho int `json:"tamanho,omitempty"`
+    Tentativas int `json:"tentativas,omitempty"` // se a central estiver ocupada ou inacessível, default 1
+}
+
+// Duração máxima de um comando via API de controle, incluindo novas tentativas
+const timeoutComandoControle = 120 * time.Second
+
+func (r *ReceptorIP) central_conectada(mac string) bool {
+    return r.sessao_central(mac) != nil
+}
+
+// Handler HTTP da API de controle
+func (r *ReceptorIP) HandlerControle() http.Handler {
+    mux := http.NewServeMux()
+    mux.HandleFunc("GET /centrais", r.controle_centrais)
+    mux.HandleFunc("GET /comandos", r.controle_comandos)
+    mux.HandleFunc("POST /centrais/{mac}/comando", r.controle_comando)
+    if r.cfg.ControleToken == "" {
+        return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+            if !host_local(req.Host) {
+                responder_erro(w, http.StatusForbidden, "Host não permitido: " + req.Host)
+                return
+            }
+            mux.ServeHTTP(w, req)
+        })
+    }
+    esperado := []byte("Bearer " + r.cfg.ControleToken)
+    return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+        if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), esperado) != 1 {
+            responder_erro(w, http.StatusUnauthorized, "Token de autorização ausente ou inválido")
+            return
+        }
+        mux.ServeHTTP(w, req)
+    })
+}
+
+// Testa se o cabeçalho Host da requisição se refere ao host local
+func host_local(host string) bool {
+    if h, _, err := net.SplitHostPort(host); err == nil {
+        host = h
+    }
+    host = strings.Trim(host, "[]")
+    if strings.EqualFold(host, "localhost") {
+        return true
+    }
+    ip := net.ParseIP(host)
+    return ip != nil && ip.IsLoopback()
+}
+
+// Inicia o servidor HTTP da API de controle
+func (r *ReceptorIP) iniciar_controle() error {
+    l, err := net.Listen("tcp", r.cfg.Controle)
+    if err != nil {
+        return err
+    }
+    fmt.Printf("ReceptorIP: API de controle em %s\n", l.Addr())
+    r.controle_addr = l.Addr()
+    r.controle = &http.Server{Handler: r.HandlerControle(), ReadHeaderTimeout: 10 * time.Second}
+    go func() {
+        err := r.controle.Serve(l)
+        fmt.Println("ReceptorIP: API de controle encerrada:", err)
+    }()
+    return nil
+}
+
+func responder_json(w http.ResponseWriter, status int, dados any) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(dados)
+}
+
+func responder_erro(w http.ResponseWriter, status int, erro string) {
+    responder_json(w, status, RelatorioComando{Sucesso: false, Erro: erro})
+}
+
+func (r *ReceptorIP) controle_centrais(w http.ResponseWriter, _ *http.Request) {
+    responder_json(w, http.StatusOK, r.Centrais())
+}
+
+func (r *ReceptorIP) controle_comandos(w http.ResponseWriter, _ *http.Request) {
+    comandos := map[string]string{}
+    for nome, descritor := range Subcomandos {
+        comandos[nome] = strings.TrimSpace(descritor.Sinopse() + " " + descritor.ExtraHelp)
+    }
+    responder_json(w, http.StatusOK, comandos)
+}
+
+func (r *ReceptorIP) controle_comando(w http.ResponseWriter, req *http.Request) {
+    if tipo, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); tipo != "application/json" {
+        responder_erro(w, http.StatusUnsupportedMediaType, "Content-Type deve ser application/json")
+        return
+    }
+
+    mac := strings.ToLower(req.PathValue("mac"))
+    if !r.central_conectada(mac) {
+        // evita novas tentativas inúteis
+        responder_erro(w, http.StatusNotFound, ErrCentralDesconectada.Error())
+        return
+    }
+
+    var requisicao RequisicaoComando
+    if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, 64 * 1024)).Decode(&requisicao); err != nil {
+        responder_erro(w, http.StatusBadRequest, fmt.Sprintf("Requisição inválida: %v", err))
+        return
+    }
+
+    comandos := SepararComandos([]string{requisicao.Comando})
+    for i, comando := range comandos {
+        if comando[0] != "foto" {
+            continue
+        }
+        // o cliente não escolhe onde gravar arquivos no host
+        if len(comando) > 3 {
+            responder_erro(w, http.StatusBadRequest, "foto: pasta não pode ser especificada via API de controle")
+            return
+        }
+        if len(comando) == 3 {
+            comandos[i] = append(comando, r.cfg.FolderDlFoto)
+        }
+    }
+
+    subs, errstring := ConstruirComandos(comandos)
+    if errstring != "" {
+        responder_erro(w, http.StatusBadRequest, errstring)
+        return
+    }
+
+    cred := Credenciais{r.cfg.Senha, r.cfg.TamSenha}
+    if requisicao.Tamanho != 0 {
+        cred = Credenciais{requisicao.Senha, requisicao.Tamanho}
+    }
+    if cred.TamSenha != 4 && cred.TamSenha != 6 {
+        responder_erro(w, http.StatusBadRequest, "Senha não configurada ou tamanho de senha inválido")
+        return
+    }
+
+    // como em gocomandar, novas tentativas apenas se solicitadas: um comando repetido após
+    // falha de conexão pode ser executado duas vezes
+    if requisicao.Tentativas < 0 {
+        responder_erro(w, http.StatusBadRequest, "Número de tentativas inválido")
+        return
+    }
+    politica := SemRetry
+    if requisicao.Tentativas > 1 {
+        politica = PoliticaRetryDefault
+        politica.Tentativas = requisicao.Tentativas
+    }
+
+    fmt.Printf("ReceptorIP: API de controle: central %s comando %s\n", mac, requisicao.Comando)
+    ctx, cancel := context.WithTimeout(req.Context(), timeoutComandoControle)
+    defer cancel()
+    relatorio, err := r.Executar(ctx, mac, cred, politica, subs...)
+
+    status := http.StatusOK
+    if errors.Is(err, ErrCentralDesconectada) {
+        status = http.StatusNotFound
+    } else if err != nil {
+        status = http.StatusBadGateway
+    }
+    responder_json(w, status, relatorio)
+}
@@ -0,0 +1,150 @@
+package goalarmeitbl
+
+import (
+    "testing"
+    "strings"
+    "net/http"
+    "net/http/httptest"
+    "encoding/json"
+    "time"
+)
+
+func TestControle(t *testing.T) {
+    r := receptorteste(t, "senha = 123456\ntamanho = 6\ncaddr = receptor\n" +
+        "gancho_central = true\ngancho_ev = true\ngancho_msg = true\ngancho_watchdog = true\n")
+    srv := httptest.NewServer(r.HandlerControle())
+    defer srv.Close()
+
+    centralfake_receptor(t, r, []byte{0xaa, 0xbb, 0x0d}, func(cmd int, payload []byte) (int, []byte) {
+        if cmd == 0x401e {
+            return 0x401e, nil
+        }
+        return 0, nil
+    })
+
+    // aguarda identificação
+    var centrais []RegistroCentral
+    for range 50 {
+        resp, err := http.Get(srv.URL + "/centrais")
+        if err != nil {
+            t.Fatal(err)
+        }
+        json.NewDecoder(resp.Body).Decode(&centrais)
+        resp.Body.Close()
+        if len(centrais) > 0 {
+            break
+        }
+        time.Sleep(20 * time.Millisecond)
+    }
+    if len(centrais) != 1 || centrais[0].Mac != "aa:bb:0d" || centrais[0].Conta != 1234 || !centrais[0].Conectada {
+        t.Fatalf("Unexpected list %v", centrais)
+    }
+
+    comando := func(mac string, corpo string) (int, RelatorioComando) {
+        resp, err := http.Post(srv.URL + "/centrais/" + mac + "/comando", "application/json", strings.NewReader(corpo))
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer resp.Body.Close()
+        var relatorio RelatorioComando
+        json.NewDecoder(resp.Body).Decode(&relatorio)
+        return resp.StatusCode, relatorio
+    }
+
+    status, relatorio := comando("AA:BB:0D", `{"comando": "desativar 1"}`)
+    if status != http.StatusOK || !relatorio.Sucesso {
+        t.Errorf("Unexpected result %d %v", status, relatorio)
+    }
+
+    status, _ = comando("aa:bb:0d", `{"comando": "naoexiste"}`)
+    if status != http.StatusBadRequest {
+        t.Errorf("Expected bad request, got %d", status)
+    }
+
+    status, _ = comando("aa:bb:0d", `{"comando": "foto 1 0 /etc"}`)
+    if status != http.StatusBadRequest {
+        t.Errorf("Client-chosen photo folder should be rejected, got %d", status)
+    }
+
+    status, _ = comando("aa:bb:0d", `{"comando": "status", "tentativas": -1}`)
+    if status != http.StatusBadRequest {
+        t.Errorf("Negative retry count should be rejected, got %d", status)
+    }
+
+    status, _ = comando("aa:bb:ff", `{"comando": "status"}`)
+    if status != http.StatusNotFound {
+        t.Errorf("Expected not found, got %d", status)
+    }
+
+    // POST "simples" de formulário ou texto, que um navegador envia sem preflight CORS
+    resp, err := http.Post(srv.URL + "/centrais/aa:bb:0d/comando", "text/plain", strings.NewReader(`{"comando": "desativar 1"}`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusUnsupportedMediaType {
+        t.Errorf("Non-JSON content type should be rejected, got %d", resp.StatusCode)
+    }
+
+    // DNS rebinding: nome externo resolvido para 127.0.0.1
+    req, _ := http.NewRequest("GET", srv.URL + "/centrais", nil)
+    req.Host = "atacante.example.com"
+    resp, err = http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusForbidden {
+        t.Errorf("Non-local Host should be rejected, got %d", resp.StatusCode)
+    }
+}
+
+func TestHostLocal(t *testing.T) {
+    for host, esperado := range map[string]bool{"localhost": true, "LOCALHOST:9011": true, "127.0.0.1:9011": true,
+            "[::1]:9011": true, "::1": true, "127.0.0.2": true, "192.168.1.10:9011": false,
+            "atacante.example.com": false, "localhost.example.com:9011": false, "": false} {
+        if host_local(host) != esperado {
+            t.Errorf("Host %q: expected %v", host, esperado)
+        }
+    }
+}
+
+func TestConfigControle(t *testing.T) {
+    f := strings.NewReader("[receptorip]\ncontrole = 127.0.0.1\n" +
+        "gancho_central = x\ngancho_ev = x\ngancho_msg = x\ngancho_watchdog = x\n")
+    _, err := NewReceptorIPConfig(f)
+    if err == nil {
+        t.Error("Should have failed")
+    }
+}
+
+func TestControleToken(t *testing.T) {
+    r := &ReceptorIP{registro: make(map[string]*RegistroCentral)}
+    r.cfg.ControleToken = "segredo"
+    srv := httptest.NewServer(r.HandlerControle())
+    defer srv.Close()
+
+    for token, esperado := range map[string]int{"": http.StatusUnauthorized, "Bearer errado": http.StatusUnauthorized,
+            "Bearer segredo": http.StatusOK} {
+        req, _ := http.NewRequest("GET", srv.URL + "/centrais", nil)
+        if token != "" {
+            req.Header.Set("Authorization", token)
+        }
+        resp, err := http.DefaultClient.Do(req)
+        if err != nil {
+            t.Fatal(err)
+        }
+        resp.Body.Close()
+        if resp.StatusCode != esperado {
+            t.Errorf("Token %q: expected %d, got %d", token, esperado, resp.StatusCode)
+        }
+    }
+
+    for cfg, valida := range map[string]bool{"controle = 0.0.0.0:9011\n": false, "controle = :9011\n": false,
+            "controle = 0.0.0.0:9011\ncontrole_token = abc\n": true, "controle = localhost:9011\n": true} {
+        _, err := NewReceptorIPConfig(strings.NewReader("[receptorip]\n" + cfg))
+        if (err == nil) != valida {
+            t.Errorf("Config %q: unexpected result %v", cfg, err)
+        }
+    }
+}
@@ -0,0 +1,168 @@
+package goalarmeitbl
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// Tratador de fotos obtidas via eventos 0xb5. Desacoplado do tratador
+// principal pois usa conexões separadas, e as fotos ficam armazenadas
+// por tempo indeterminado na central, não sendo atreladas à conexão com
+// o Receptor IP.
+
+type fotoPendente struct {
+    ip_addr string // endereço de origem da conexão da central
+    macaddr string // identificação da central, para uso do túnel ISECNet2
+    indice int
+    nrfoto int
+    tentativas int
+}
+
+type resultadoFoto struct {
+    status int
+    erro error
+    sub *ObterFoto
+}
+
+type TratadorFotos struct {
+    receptor *ReceptorIP
+    cfg ReceptorIPConfig
+    events chan Event
+    fila []fotoPendente
+    task *Timeout
+    // cancelado no encerramento do receptor, interrompendo a goroutine e o download em andamento
+    ctx context.Context
+    cancel context.CancelFunc
+    wg sync.WaitGroup
+}
+
+func NewTratadorFotos(receptor *ReceptorIP, cfg ReceptorIPConfig) *TratadorFotos {
+    t := new(TratadorFotos)
+    t.receptor = receptor
+    t.cfg = cfg
+    t.events = make(chan Event, 10)
+    t.ctx, t.cancel = context.WithCancel(context.Background())
+
+    t.wg.Go(func() {
+        for {
+            var evt Event
+            select {
+            case <-t.ctx.Done():
+                if t.task != nil {
+                    t.task.Free()
+                    t.task = nil
+                }
+                return
+            case evt = <-t.events:
+            }
+
+            switch evt.Name {
+            case "Enfileirar":
+                t.enfileirar(evt.Cargo.(fotoPendente))
+            case "ObtemFoto":
+                t.obtem_foto()
+            case "Resultado":
+                t.resultado_foto(evt.Cargo.(resultadoFoto))
+            }
+        }
+    })
+
+    return t
+}
+
+// Recebe nova foto de algum tratador para a fila
+func (t *TratadorFotos) Enfileirar(ip_addr string, macaddr string, indice int, nrfoto int) {
+    select {
+    case t.events <-Event{"Enfileirar", fotoPendente{ip_addr, macaddr, indice, nrfoto, 10}}:
+    case <-t.ctx.Done():
+    }
+}
+
+// Encerra o tratador, descartando as fotos pendentes. Retorna após o fim da goroutine
+// e do download em andamento, se houver
+func (t *TratadorFotos) Close() {
+    t.cancel()
+    t.wg.Wait()
+}
+
+// Todos os métodos abaixo são invocados apenas pela goroutine e são privados
+
+func (t *TratadorFotos) enfileirar(foto fotoPendente) {
+    t.fila = append(t.fila, foto)
+    if t.task == nil {
+        // Fotos de sensor 8000 demoram para gravar (NAK 0x28 = foto não gravada)
+        t.task = NewTimeout(20 * time.Second, 0, t.events, "ObtemFoto", nil)
+    }
+}
+
+func (t *TratadorFotos) obtem_foto() {
+    if len(t.fila) == 0 {
+        t.task.Free()
+        t.task = nil
+        return
+    }
+
+    foto := t.fila[0]
+
+    // Usar túnel na conexão da central com o receptor, endereço da central detectado,
+    // ou endereço manualmente especificado?
+    var conector ConectorComando
+    var addr string
+    if t.cfg.Caddr == "receptor" {
+        addr = "receptor/" + foto.macaddr
+        conector = t.receptor.ConectorTunel(foto.macaddr)
+    } else {
+        ip_addr := foto.ip_addr
+        if t.cfg.Caddr != "auto" {
+            ip_addr = t.cfg.Caddr
+        }
+        addr = net.JoinHostPort(ip_addr, strconv.Itoa(t.cfg.Cport))
+        conector = ConectorTCP(addr)
+    }
+
+    fmt.Printf("TratadorFotos: obtendo %s:%d:%d tentativas %d\n", addr, foto.indice, foto.nrfoto, foto.tentativas)
+
+    sub := NewObterFoto(foto.indice, foto.nrfoto, t.cfg.FolderDlFoto)
+    comando := NewSequenciaComandosTransporte(t.ctx, []ComandoCentralSub{sub}, conector,
+        t.cfg.Senha, t.cfg.TamSenha, SemRetry)
+    t.wg.Go(func() {
+        status := comando.Resultado() // bloqueia
+        select {
+        case t.events <-Event{"Resultado", resultadoFoto{status, comando.Erro(), sub}}:
+        case <-t.ctx.Done():
+        }
+    })
+}
+
+func (t *TratadorFotos) resultado_foto(res resultadoFoto) {
+    foto := &t.fila[0]
+    // NAK permanente (e.g. evento sem foto associada): não adianta tentar novamente
+    var erro_nak *ErroNak
+    nak_permanente := errors.As(res.erro, &erro_nak) && !erro_nak.Motivo.Repetivel()
+
+    if res.status == 0 {
+        fmt.Printf("TratadorFotos: foto %d:%d: sucesso, arquivo %s\n", foto.indice, foto.nrfoto, res.sub.Arquivo)
+        dados := t.receptor.dados_central(foto.macaddr)
+        dados.Arquivo = res.sub.Arquivo
+        t.receptor.invoca_gancho_central(foto.macaddr, "arquivo", dados, res.sub.Arquivo)
+        t.fila = t.fila[1:]
+    } else if res.sub.Fatal || nak_permanente {
+        fmt.Printf("TratadorFotos: foto %d:%d: erro fatal: %s\n", foto.indice, foto.nrfoto, res.erro)
+        t.fila = t.fila[1:]
+    } else {
+        foto.tentativas -= 1
+        if foto.tentativas <= 0 {
+            fmt.Printf("TratadorFotos: foto %d:%d: tentativas esgotadas\n", foto.indice, foto.nrfoto)
+            t.fila = t.fila[1:]
+        } else {
+            fmt.Printf("TratadorFotos: foto %d:%d: erro temporário: %s\n", foto.indice, foto.nrfoto, res.erro)
+        }
+    }
+
+    t.task.Restart()
+}
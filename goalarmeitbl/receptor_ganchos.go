@@ -0,0 +1,282 @@
+package goalarmeitbl
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "os"
+    "os/exec"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// Despachante de ganchos: executa os scripts em goroutines próprias, para que um script lento
+// não atrase o protocolo com a central. As execuções de uma mesma chave (o MAC da central, ou ""
+// para ganchos do próprio receptor) ocorrem na ordem de enfileiramento, uma de cada vez.
+// Execuções de chaves diferentes ocorrem em paralelo, limitadas por tipo de gancho.
+// Cada tipo de gancho pode ter vários scripts, executados em sequência, e os consumidores
+// em processo recebem todos os tipos de gancho, em paralelo com os scripts.
+
+var ErrFilaGanchosCheia = errors.New("fila de ganchos cheia")
+
+// Dados estruturados entregues a todo gancho, além dos parâmetros de linha de comando:
+// como documento JSON na entrada padrão e como variáveis de ambiente ALARME_*
+type DadosGancho struct {
+    Tipo string `json:"tipo"`                 // central, ev, msg, watchdog, arquivo
+    Quando time.Time `json:"quando"`
+    Args []string `json:"args"`               // parâmetros de linha de comando
+    Mac string `json:"mac,omitempty"`         // identidade da central, se conhecida
+    Conta int `json:"conta,omitempty"`
+    Canal string `json:"canal,omitempty"`
+    Endereco string `json:"endereco,omitempty"`
+    Mensagem string `json:"mensagem,omitempty"`   // gancho_msg
+    Evento *RIPAlarme `json:"evento,omitempty"`   // gancho_ev e gancho_msg de eventos de alarme
+    Falha bool `json:"falha,omitempty"`           // gancho_central: central(is) sem comunicação
+    Arquivo string `json:"arquivo,omitempty"`     // gancho_arquivo
+}
+
+func bool_ambiente(b bool) string {
+    if b {
+        return "1"
+    }
+    return "0"
+}
+
+// Variáveis de ambiente ALARME_*; campos ausentes são omitidos
+func (d DadosGancho) Ambiente() []string {
+    amb := []string{"ALARME_TIPO=" + d.Tipo, "ALARME_QUANDO=" + d.Quando.Format(time.RFC3339)}
+    opcional := func(nome string, valor string) {
+        if valor != "" {
+            amb = append(amb, "ALARME_" + nome + "=" + valor)
+        }
+    }
+    opcional("MAC", d.Mac)
+    if d.Conta != 0 {
+        opcional("CONTA", strconv.Itoa(d.Conta))
+    }
+    opcional("CANAL", d.Canal)
+    opcional("ENDERECO", d.Endereco)
+    opcional("MENSAGEM", d.Mensagem)
+    opcional("ARQUIVO", d.Arquivo)
+    if d.Tipo == "central" {
+        opcional("FALHA", bool_ambiente(d.Falha))
+    }
+    if e := d.Evento; e != nil && e.Valido {
+        opcional("CONTACT_ID", strconv.Itoa(e.ContactId))
+        opcional("TIPO_CONTACT_ID", strconv.Itoa(e.Tipo))
+        opcional("CANAL_EVENTO", strconv.Itoa(e.Canal))
+        opcional("CODIGO", strconv.Itoa(e.Codigo))
+        opcional("QUALIFICADOR", strconv.Itoa(e.Qualificador))
+        opcional("PARTICAO", strconv.Itoa(e.Particao))
+        opcional("ZONA", strconv.Itoa(e.Zona))
+        opcional("CODIGO_CONHECIDO", bool_ambiente(e.CodigoConhecido))
+        opcional("DESCRICAO", e.DescricaoHumana)
+        if e.NrFotos > 0 {
+            opcional("INDICE_FOTOS", strconv.Itoa(e.IndiceFotos))
+            opcional("NR_FOTOS", strconv.Itoa(e.NrFotos))
+        }
+    }
+    return amb
+}
+
+// Consumidor de eventos em processo, para uso do pacote como biblioteca: recebe os mesmos dados
+// entregues aos scripts de gancho, como valores Go. É invocado pelo despachante uma única vez por
+// gancho, em paralelo com os scripts; novas tentativas ficam a cargo do consumidor. Um erro retornado,
+// ou o esgotamento do prazo do consumidor, conta como falha do gancho
+type ConsumidorEventos interface {
+    Consumir(dados DadosGancho) error
+}
+
+// Adaptador para usar uma função como ConsumidorEventos
+type FuncConsumidor func(dados DadosGancho) error
+
+func (f FuncConsumidor) Consumir(dados DadosGancho) error {
+    return f(dados)
+}
+
+type tarefaGancho struct {
+    tipo string
+    args []string
+    dados DadosGancho
+    resultado chan error
+}
+
+type DespachanteGanchos struct {
+    ganchos map[string][]string
+    consumidores []ConsumidorEventos
+    capacidade int           // número máximo de execuções pendentes, incluindo as em andamento
+    concorrencia int         // execuções simultâneas por tipo de gancho
+    timeout time.Duration    // por tentativa
+    politica PoliticaRetry   // novas tentativas se o script falhar (não se aplica a consumidores)
+    timeout_consumidor time.Duration
+
+    mutex sync.Mutex
+    pendentes int
+    filas map[string][]*tarefaGancho // por chave; a primeira tarefa está em execução
+    semaforos map[string]chan bool   // por tipo de gancho
+}
+
+func NewDespachanteGanchos(ganchos map[string][]string, consumidores []ConsumidorEventos, capacidade int,
+        concorrencia int, timeout time.Duration, politica PoliticaRetry,
+        timeout_consumidor time.Duration) *DespachanteGanchos {
+    d := new(DespachanteGanchos)
+    d.ganchos = ganchos
+    d.consumidores = consumidores
+    d.capacidade = capacidade
+    d.concorrencia = concorrencia
+    d.timeout = timeout
+    d.politica = politica
+    d.timeout_consumidor = timeout_consumidor
+    d.filas = make(map[string][]*tarefaGancho)
+    d.semaforos = make(map[string]chan bool)
+    return d
+}
+
+// Enfileira execução do gancho. O canal retornado recebe o resultado final, após as eventuais
+// novas tentativas, e pode ser ignorado. Retorna erro se a fila está cheia
+// Tipo, parâmetros e horário (se não informado) são preenchidos em dados
+func (d *DespachanteGanchos) Enfileirar(chave string, tipo string, dados DadosGancho, args ...string) (chan error, error) {
+    resultado := make(chan error, 1)
+    if len(d.ganchos["gancho_" + tipo]) == 0 && len(d.consumidores) == 0 {
+        // gancho não configurado
+        resultado <- nil
+        return resultado, nil
+    }
+
+    d.mutex.Lock()
+    defer d.mutex.Unlock()
+
+    if d.pendentes >= d.capacidade {
+        fmt.Printf("DespachanteGanchos: fila cheia, gancho %s descartado\n", tipo)
+        return nil, ErrFilaGanchosCheia
+    }
+    d.pendentes += 1
+    dados.Tipo = tipo
+    dados.Args = args
+    if dados.Quando.IsZero() {
+        dados.Quando = time.Now()
+    }
+    d.filas[chave] = append(d.filas[chave], &tarefaGancho{tipo, args, dados, resultado})
+    if len(d.filas[chave]) == 1 {
+        go d.processar_fila(chave)
+    }
+    return resultado, nil
+}
+
+// Executa as tarefas de uma chave em ordem, até esvaziar a fila
+func (d *DespachanteGanchos) processar_fila(chave string) {
+    for {
+        d.mutex.Lock()
+        tarefa := d.filas[chave][0]
+        semaforo, ok := d.semaforos[tarefa.tipo]
+        if !ok {
+            semaforo = make(chan bool, d.concorrencia)
+            d.semaforos[tarefa.tipo] = semaforo
+        }
+        d.mutex.Unlock()
+
+        semaforo <- true
+        err := d.executar(tarefa)
+        <-semaforo
+        tarefa.resultado <- err
+
+        d.mutex.Lock()
+        d.pendentes -= 1
+        d.filas[chave] = d.filas[chave][1:]
+        if len(d.filas[chave]) == 0 {
+            delete(d.filas, chave)
+            d.mutex.Unlock()
+            return
+        }
+        d.mutex.Unlock()
+    }
+}
+
+// Executa todos os scripts e consumidores; retorna os erros dos que falharam
+func (d *DespachanteGanchos) executar(tarefa *tarefaGancho) error {
+    // consumidores não esperam pelos scripts, nem fazem parte das novas tentativas destes
+    prazo := time.Now().Add(d.timeout_consumidor)
+    resultados := make([]chan error, len(d.consumidores))
+    for i, consumidor := range d.consumidores {
+        resultados[i] = make(chan error, 1)
+        go func() {
+            resultados[i] <- consumidor.Consumir(tarefa.dados)
+        }()
+    }
+
+    erros := []error{}
+    for _, script := range d.ganchos["gancho_" + tarefa.tipo] {
+        erros = append(erros, d.com_tentativas(tarefa.tipo, script, func() error {
+            return d.executar_script(script, tarefa)
+        }))
+    }
+    for i, consumidor := range d.consumidores {
+        erros = append(erros, d.aguardar_consumidor(tarefa.tipo, consumidor, resultados[i], prazo))
+    }
+    return errors.Join(erros...)
+}
+
+// Esgotado o prazo, o consumidor deixa de ser aguardado (mas não é interrompido), para
+// não bloquear indefinidamente a fila da chave
+func (d *DespachanteGanchos) aguardar_consumidor(tipo string, consumidor ConsumidorEventos,
+        resultado chan error, prazo time.Time) error {
+    var err error
+    select {
+    case err = <-resultado:
+    case <-time.After(time.Until(prazo)):
+        err = fmt.Errorf("timeout de %v", d.timeout_consumidor)
+    }
+    if err != nil {
+        fmt.Printf("DespachanteGanchos: %s consumidor %T falhou com erro %v\n", tipo, consumidor, err)
+    }
+    return err
+}
+
+func (d *DespachanteGanchos) com_tentativas(tipo string, nome string, f func() error) error {
+    for tentativa := 1; ; tentativa++ {
+        err := f()
+        if err == nil {
+            return nil
+        }
+        fmt.Printf("DespachanteGanchos: %s %s falhou com erro %v (tentativa %d de %d)\n",
+            tipo, nome, err, tentativa, d.politica.Tentativas)
+        if tentativa >= d.politica.Tentativas {
+            return err
+        }
+        time.Sleep(d.politica.intervalo(tentativa))
+    }
+}
+
+// Ao fim do prazo, o grupo de processos do script é encerrado, incluindo eventuais subprocessos
+func (d *DespachanteGanchos) executar_script(script string, tarefa *tarefaGancho) error {
+    entrada, err := json.Marshal(tarefa.dados)
+    if err != nil {
+        return err
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+    defer cancel()
+
+    cmd := exec.CommandContext(ctx, script, tarefa.args...)
+    grupo_processos(cmd)
+    cmd.WaitDelay = time.Second
+    cmd.Env = append(os.Environ(), tarefa.dados.Ambiente()...)
+    cmd.Stdin = bytes.NewReader(entrada)
+    log.Printf("DespachanteGanchos: executando %s %v", script, tarefa.args)
+    err = cmd.Run()
+    if ctx.Err() == context.DeadlineExceeded {
+        return fmt.Errorf("timeout de %v: %w", d.timeout, err)
+    }
+    return err
+}
+
+// Número de execuções pendentes, incluindo as em andamento
+func (d *DespachanteGanchos) Pendentes() int {
+    d.mutex.Lock()
+    defer d.mutex.Unlock()
+    return d.pendentes
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package goalarmeitbl
+
+import (
+    "os/exec"
+)
+
+// Sem grupos de processos, o timeout encerra apenas o processo do script
+func grupo_processos(cmd *exec.Cmd) {
+}
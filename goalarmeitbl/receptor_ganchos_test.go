@@ -0,0 +1,260 @@
+package goalarmeitbl
+
+import (
+    "testing"
+    "encoding/json"
+    "errors"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+// Gancho que dorme o tempo informado no primeiro parâmetro e registra o segundo num arquivo
+func ganchodemorado(t *testing.T) (map[string][]string, string) {
+    dir := t.TempDir()
+    saida := filepath.Join(dir, "saida.txt")
+    script := filepath.Join(dir, "gancho")
+    conteudo := "#!/bin/sh\nsleep $1\necho $2 >> " + saida + "\n"
+    if err := os.WriteFile(script, []byte(conteudo), 0755); err != nil {
+        t.Fatal(err)
+    }
+    return map[string][]string{"gancho_ev": {script}}, saida
+}
+
+func TestGanchosOrdem(t *testing.T) {
+    ganchos, saida := ganchodemorado(t)
+    d := NewDespachanteGanchos(ganchos, nil, 10, 4, 5 * time.Second, SemRetry, time.Second)
+
+    d.Enfileirar("aa:bb:01", "ev", DadosGancho{}, "0.3", "a1")
+    d.Enfileirar("aa:bb:01", "ev", DadosGancho{}, "0", "a2")
+    res, _ := d.Enfileirar("aa:bb:02", "ev", DadosGancho{}, "0", "b1")
+    if err := <-res; err != nil {
+        t.Fatal(err)
+    }
+    // gancho não configurado
+    res, err := d.Enfileirar("aa:bb:01", "msg", DadosGancho{}, "x")
+    if err != nil || <-res != nil {
+        t.Error("Unconfigured hook should succeed immediately")
+    }
+
+    linhas := aguardalinhas(t, saida, 3)
+    if strings.Join(linhas, " ") != "b1 a1 a2" {
+        t.Errorf("Unexpected order %v", linhas)
+    }
+}
+
+func TestGanchosConcorrencia(t *testing.T) {
+    ganchos, saida := ganchodemorado(t)
+    d := NewDespachanteGanchos(ganchos, nil, 2, 1, 5 * time.Second, SemRetry, time.Second)
+
+    inicio := time.Now()
+    d.Enfileirar("aa:bb:01", "ev", DadosGancho{}, "0.2", "a1")
+    d.Enfileirar("aa:bb:02", "ev", DadosGancho{}, "0.2", "b1")
+    if _, err := d.Enfileirar("aa:bb:03", "ev", DadosGancho{}, "0", "c1"); !errors.Is(err, ErrFilaGanchosCheia) {
+        t.Errorf("Queue should be full, got %v", err)
+    }
+    aguardalinhas(t, saida, 2)
+    if time.Since(inicio) < 400 * time.Millisecond {
+        t.Error("Concurrency limit not respected")
+    }
+    for d.Pendentes() > 0 {
+        time.Sleep(10 * time.Millisecond)
+    }
+}
+
+func TestGanchosRetry(t *testing.T) {
+    dir := t.TempDir()
+    script := filepath.Join(dir, "gancho")
+    // falha nas duas primeiras invocações
+    conteudo := "#!/bin/sh\necho x >> " + dir + "/n\n[ $(wc -l < " + dir + "/n) -ge 3 ]\n"
+    if err := os.WriteFile(script, []byte(conteudo), 0755); err != nil {
+        t.Fatal(err)
+    }
+    d := NewDespachanteGanchos(map[string][]string{"gancho_ev": {script}}, nil, 10, 4, 5 * time.Second,
+        PoliticaRetry{3, 10 * time.Millisecond, 100 * time.Millisecond}, time.Second)
+
+    res, _ := d.Enfileirar("", "ev", DadosGancho{})
+    if err := <-res; err != nil {
+        t.Errorf("Hook should succeed on third attempt: %v", err)
+    }
+    res, _ = d.Enfileirar("", "ev", DadosGancho{})
+    if err := <-res; err != nil {
+        t.Errorf("Hook should succeed: %v", err)
+    }
+
+    os.Remove(filepath.Join(dir, "n"))
+    d.politica = PoliticaRetry{2, 10 * time.Millisecond, 100 * time.Millisecond}
+    res, _ = d.Enfileirar("", "ev", DadosGancho{})
+    if err := <-res; err == nil {
+        t.Error("Hook should fail after retries exhausted")
+    }
+}
+
+func TestGanchosTimeout(t *testing.T) {
+    dir := t.TempDir()
+    script := filepath.Join(dir, "gancho")
+    pidfile := filepath.Join(dir, "pid")
+    // subprocesso que sobreviveria ao script se apenas este fosse encerrado
+    conteudo := "#!/bin/sh\nsleep 30 &\necho $! > " + pidfile + "\nwait\n"
+    if err := os.WriteFile(script, []byte(conteudo), 0755); err != nil {
+        t.Fatal(err)
+    }
+    d := NewDespachanteGanchos(map[string][]string{"gancho_ev": {script}}, nil, 10, 4, 200 * time.Millisecond, SemRetry,
+        time.Second)
+
+    inicio := time.Now()
+    res, _ := d.Enfileirar("", "ev", DadosGancho{})
+    if err := <-res; err == nil || !strings.Contains(err.Error(), "timeout") {
+        t.Errorf("Expected timeout, got %v", err)
+    }
+    if time.Since(inicio) > 2 * time.Second {
+        t.Error("Timeout took too long")
+    }
+
+    pid, _ := os.ReadFile(pidfile)
+    if _, err := os.Stat("/proc/self"); err != nil {
+        t.Skip("/proc not available")
+    }
+    for range 100 {
+        stat, err := os.ReadFile("/proc/" + strings.TrimSpace(string(pid)) + "/stat")
+        // processo inexistente ou zumbi
+        if err != nil || strings.Contains(string(stat), ") Z ") {
+            return
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    t.Error("Subprocess survived the timeout")
+}
+
+func TestGanchosDadosEstruturados(t *testing.T) {
+    script, saida := ganchoteste(t)
+    dir := filepath.Dir(saida)
+    // parâmetros, variáveis de ambiente e entrada padrão
+    conteudo := "#!/bin/sh\necho \"$@\" > " + dir + "/args\nenv | grep ^ALARME_ | sort > " + dir + "/env\n" +
+        "cat > " + dir + "/stdin\n"
+    if err := os.WriteFile(script, []byte(conteudo), 0755); err != nil {
+        t.Fatal(err)
+    }
+    r := receptorteste(t, "gancho_ev = " + script + "\nconfirmacao = gancho\n")
+
+    c := centralfake_receptor(t, r, []byte{0xaa, 0xbb, 0x05}, func(int, []byte) (int, []byte) {
+        return 0, nil
+    })
+    c.Write(pacoteevento(130, 1, 5))
+    aguardalinhas(t, filepath.Join(dir, "stdin"), 1)
+
+    args, _ := os.ReadFile(filepath.Join(dir, "args"))
+    if strings.TrimSpace(string(args)) != "130 1 5 1" {
+        t.Errorf("Unexpected argv %s", args)
+    }
+
+    amb, _ := os.ReadFile(filepath.Join(dir, "env"))
+    for _, esperado := range []string{"ALARME_TIPO=ev", "ALARME_MAC=aa:bb:05", "ALARME_CONTA=1234",
+            "ALARME_CANAL=Ethernet", "ALARME_CODIGO=130", "ALARME_PARTICAO=1", "ALARME_ZONA=5",
+            "ALARME_QUALIFICADOR=1", "ALARME_CONTACT_ID=1234", "ALARME_CODIGO_CONHECIDO=1"} {
+        if !strings.Contains(string(amb), esperado + "\n") {
+            t.Errorf("Missing %s in environment %s", esperado, amb)
+        }
+    }
+
+    entrada, _ := os.ReadFile(filepath.Join(dir, "stdin"))
+    var dados DadosGancho
+    if err := json.Unmarshal(entrada, &dados); err != nil {
+        t.Fatal(err)
+    }
+    if dados.Tipo != "ev" || dados.Mac != "aa:bb:05" || dados.Conta != 1234 || dados.Evento == nil ||
+            dados.Evento.Zona != 5 || dados.Evento.DescricaoHumana == "" || len(dados.Args) != 1 || dados.Quando.IsZero() {
+        t.Errorf("Unexpected JSON %s", entrada)
+    }
+}
+
+func TestGanchosMultiplos(t *testing.T) {
+    script1, saida1 := ganchoteste(t)
+    script2, saida2 := ganchoteste(t)
+    recebidos := make(chan DadosGancho, 10)
+    falhar := true
+    consumidor := FuncConsumidor(func(dados DadosGancho) error {
+        if falhar {
+            falhar = false
+            return errors.New("falha transitória")
+        }
+        recebidos <- dados
+        return nil
+    })
+    d := NewDespachanteGanchos(map[string][]string{"gancho_ev": {script1, script2}}, []ConsumidorEventos{consumidor},
+        10, 4, 5 * time.Second, PoliticaRetry{2, 10 * time.Millisecond, 10 * time.Millisecond}, time.Second)
+
+    // o consumidor não é repetido pelo despachante, apenas os scripts
+    res, _ := d.Enfileirar("aa:bb:01", "ev", DadosGancho{Mac: "aa:bb:01"}, "130 1 5 1")
+    if err := <-res; err == nil {
+        t.Fatal("Consumer failure should be reported")
+    }
+    res, _ = d.Enfileirar("aa:bb:01", "ev", DadosGancho{Mac: "aa:bb:01"}, "130 1 5 1")
+    if err := <-res; err != nil {
+        t.Fatal(err)
+    }
+    aguardalinhas(t, saida1, 1)
+    aguardalinhas(t, saida2, 1)
+    if dados := <-recebidos; dados.Tipo != "ev" || dados.Mac != "aa:bb:01" || dados.Args[0] != "130 1 5 1" {
+        t.Errorf("Unexpected data %+v", dados)
+    }
+
+    // consumidor recebe também tipos sem script configurado
+    res, _ = d.Enfileirar("", "watchdog", DadosGancho{})
+    if err := <-res; err != nil {
+        t.Fatal(err)
+    }
+    if dados := <-recebidos; dados.Tipo != "watchdog" {
+        t.Errorf("Unexpected data %+v", dados)
+    }
+}
+
+func TestConsumidorTimeout(t *testing.T) {
+    iniciado := make(chan bool, 1)
+    libera := make(chan bool)
+    defer close(libera)
+    lento := FuncConsumidor(func(dados DadosGancho) error {
+        iniciado <- true
+        <-libera
+        return nil
+    })
+    d := NewDespachanteGanchos(map[string][]string{"gancho_ev": {"sleep"}}, []ConsumidorEventos{lento},
+        10, 4, 5 * time.Second, SemRetry, 100 * time.Millisecond)
+
+    res, _ := d.Enfileirar("aa:bb:01", "ev", DadosGancho{}, "0.5")
+    // consumidor inicia sem esperar pelo script
+    select {
+    case <-iniciado:
+    case <-time.After(300 * time.Millisecond):
+        t.Fatal("Consumer should run in parallel with scripts")
+    }
+    if err := <-res; err == nil || !strings.Contains(err.Error(), "timeout") {
+        t.Errorf("Expected consumer timeout, got %v", err)
+    }
+}
+
+func TestConsumidorReceptor(t *testing.T) {
+    cfg := configteste(t, "")
+    recebidos := make(chan DadosGancho, 10)
+    cfg.Consumidores = []ConsumidorEventos{FuncConsumidor(func(dados DadosGancho) error {
+        if dados.Tipo == "ev" {
+            recebidos <- dados
+        }
+        return nil
+    })}
+    r := receptorteste_cfg(t, cfg)
+
+    c := centralfake_receptor(t, r, []byte{0xaa, 0xbb, 0x06}, func(int, []byte) (int, []byte) {
+        return 0, nil
+    })
+    c.Write(pacoteevento(401, 1, 0))
+    select {
+    case dados := <-recebidos:
+        if dados.Mac != "aa:bb:06" || dados.Evento == nil || dados.Evento.Codigo != 401 {
+            t.Errorf("Unexpected data %+v", dados)
+        }
+    case <-time.After(2 * time.Second):
+        t.Error("Event not delivered to consumer")
+    }
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package goalarmeitbl
+
+import (
+    "os/exec"
+    "syscall"
+)
+
+// Executa o script num grupo de processos próprio, para que o timeout encerre também os subprocessos
+func grupo_processos(cmd *exec.Cmd) {
+    cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+    cmd.Cancel = func() error {
+        return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+    }
+}
@@ -0,0 +1,219 @@
+package goalarmeitbl
+
+import (
+    "bufio"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "log"
+    "os"
+    "slices"
+    "sync"
+    "time"
+)
+
+// Journal persistente dos frames recebidos das centrais e dos eventos decodificados,
+// uma entrada JSON por linha, apenas com acréscimo. Quando o arquivo atinge o tamanho
+// máximo, é renomeado para <caminho>.1 (o .1 anterior para .2, etc.) e um novo é iniciado.
+// Compartilhado pelas goroutines dos tratadores, protegido por mutex próprio
+
+type EntradaJournal struct {
+    Quando time.Time `json:"quando"`
+    Mac string `json:"mac,omitempty"`       // vazio se a central ainda não se identificou
+    Conta int `json:"conta,omitempty"`
+    Endereco string `json:"endereco"`
+    Tipo int `json:"tipo"`                   // tipo do frame RIP
+    Hex string `json:"hex"`                  // frame completo, como recebido
+    Evento *RIPAlarme `json:"evento,omitempty"`
+    Duplicado bool `json:"duplicado,omitempty"` // evento ignorado pela deduplicação
+}
+
+type Journal struct {
+    caminho string
+    tam_max int64
+    arquivos int // número de arquivos rotacionados mantidos
+
+    mutex sync.Mutex
+    f *os.File
+    tam int64
+}
+
+func NewJournal(caminho string, tam_max int64, arquivos int) (*Journal, error) {
+    j := &Journal{caminho: caminho, tam_max: tam_max, arquivos: arquivos}
+    if err := j.abrir(); err != nil {
+        return nil, err
+    }
+    return j, nil
+}
+
+func (j *Journal) abrir() error {
+    f, err := os.OpenFile(j.caminho, os.O_WRONLY | os.O_APPEND | os.O_CREATE, 0644)
+    if err != nil {
+        return err
+    }
+    st, err := f.Stat()
+    if err != nil {
+        f.Close()
+        return err
+    }
+    j.f = f
+    j.tam = st.Size()
+    return nil
+}
+
+// Grava entrada e força a gravação em disco. Retorna erro se a entrada não foi persistida
+func (j *Journal) Registrar(entrada EntradaJournal) error {
+    linha, err := json.Marshal(entrada)
+    if err != nil {
+        return err
+    }
+    linha = append(linha, '\n')
+
+    j.mutex.Lock()
+    defer j.mutex.Unlock()
+
+    if j.f == nil {
+        // rotação anterior falhou; tenta novamente
+        if err := j.abrir(); err != nil {
+            return err
+        }
+    }
+    n, err := j.f.Write(linha)
+    j.tam += int64(n)
+    if err != nil {
+        return err
+    }
+    if err := j.f.Sync(); err != nil {
+        return err
+    }
+
+    if j.tam >= j.tam_max {
+        if err := j.rotacionar(); err != nil {
+            // a entrada já foi persistida, o problema é apenas a rotação
+            fmt.Printf("Journal: falha ao rotacionar %s: %v\n", j.caminho, err)
+        }
+    }
+    return nil
+}
+
+func (j *Journal) rotacionar() error {
+    log.Printf("Journal: rotacionando %s", j.caminho)
+    j.f.Close()
+    j.f = nil
+    for i := j.arquivos - 1; i >= 1; i-- {
+        origem := fmt.Sprintf("%s.%d", j.caminho, i)
+        if _, err := os.Stat(origem); err == nil {
+            if err := os.Rename(origem, fmt.Sprintf("%s.%d", j.caminho, i + 1)); err != nil {
+                return err
+            }
+        }
+    }
+    if j.arquivos > 0 {
+        if err := os.Rename(j.caminho, j.caminho + ".1"); err != nil {
+            return err
+        }
+    } else if err := os.Remove(j.caminho); err != nil {
+        return err
+    }
+    return j.abrir()
+}
+
+func (j *Journal) Close() {
+    j.mutex.Lock()
+    defer j.mutex.Unlock()
+    if j.f != nil {
+        j.f.Close()
+        j.f = nil
+    }
+}
+
+// Entrada de journal de um frame recebido pelo tratador
+func NovaEntradaJournal(endereco string, pacote PacoteRIP, bruto []byte) EntradaJournal {
+    return EntradaJournal{Quando: time.Now(), Endereco: endereco, Tipo: pacote.Tipo, Hex: hex.EncodeToString(bruto)}
+}
+
+// Critérios de consulta ao journal. Valores zero não restringem a consulta,
+// exceto Codigo e Zona, onde -1 significa qualquer um
+type FiltroJournal struct {
+    Desde time.Time
+    Ate time.Time
+    Mac string
+    Conta int
+    Codigo int
+    Zona int
+    SoEventos bool
+}
+
+func (f FiltroJournal) aceita(e EntradaJournal) bool {
+    if !f.Desde.IsZero() && e.Quando.Before(f.Desde) {
+        return false
+    }
+    if !f.Ate.IsZero() && e.Quando.After(f.Ate) {
+        return false
+    }
+    if f.Mac != "" && e.Mac != f.Mac {
+        return false
+    }
+    if f.Conta != 0 && e.Conta != f.Conta {
+        return false
+    }
+    if f.SoEventos || f.Codigo >= 0 || f.Zona >= 0 {
+        if e.Evento == nil || !e.Evento.Valido {
+            return false
+        }
+        if f.Codigo >= 0 && e.Evento.Codigo != f.Codigo {
+            return false
+        }
+        if f.Zona >= 0 && e.Evento.Zona != f.Zona {
+            return false
+        }
+    }
+    return true
+}
+
+// Arquivos do journal, do mais antigo ao mais recente
+func arquivos_journal(caminho string) []string {
+    arquivos := []string{}
+    if _, err := os.Stat(caminho); err == nil {
+        arquivos = append(arquivos, caminho)
+    }
+    for i := 1; ; i++ {
+        rotacionado := fmt.Sprintf("%s.%d", caminho, i)
+        if _, err := os.Stat(rotacionado); err != nil {
+            break
+        }
+        arquivos = append(arquivos, rotacionado)
+    }
+    slices.Reverse(arquivos)
+    return arquivos
+}
+
+// Consulta o journal, incluindo arquivos rotacionados, em ordem cronológica
+// Linhas inválidas (e.g. última linha truncada por queda de energia) são ignoradas
+func ConsultarJournal(caminho string, filtro FiltroJournal) ([]EntradaJournal, error) {
+    res := []EntradaJournal{}
+    for _, arquivo := range arquivos_journal(caminho) {
+        f, err := os.Open(arquivo)
+        if err != nil {
+            return res, err
+        }
+        scanner := bufio.NewScanner(f)
+        scanner.Buffer(nil, 1024 * 1024)
+        for scanner.Scan() {
+            var entrada EntradaJournal
+            if err := json.Unmarshal(scanner.Bytes(), &entrada); err != nil {
+                log.Printf("Journal: linha inválida em %s: %v", arquivo, err)
+                continue
+            }
+            if filtro.aceita(entrada) {
+                res = append(res, entrada)
+            }
+        }
+        err = scanner.Err()
+        f.Close()
+        if err != nil {
+            return res, err
+        }
+    }
+    return res, nil
+}
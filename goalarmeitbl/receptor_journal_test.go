@@ -0,0 +1,113 @@
+package goalarmeitbl
+
+import (
+    "testing"
+    "encoding/hex"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+func TestJournalRotacao(t *testing.T) {
+    caminho := filepath.Join(t.TempDir(), "journal.jsonl")
+    j, err := NewJournal(caminho, 200, 2)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer j.Close()
+
+    inicio := time.Now()
+    for i := range 10 {
+        evento := RIPAlarme{Valido: true, Codigo: 130, Zona: i}
+        entrada := EntradaJournal{Quando: inicio.Add(time.Duration(i) * time.Second), Mac: "aa:bb:cc", Conta: 1234,
+            Tipo: 0xb0, Hex: "00", Evento: &evento}
+        if err := j.Registrar(entrada); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    if _, err := os.Stat(caminho + ".2"); err != nil {
+        t.Errorf("Rotated file missing: %v", err)
+    }
+    if _, err := os.Stat(caminho + ".3"); err == nil {
+        t.Error("Too many rotated files kept")
+    }
+
+    entradas, err := ConsultarJournal(caminho, FiltroJournal{Codigo: -1, Zona: -1})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(entradas) == 0 || len(entradas) >= 10 {
+        t.Fatalf("Unexpected number of entries %d", len(entradas))
+    }
+    // ordem cronológica, a mais recente é sempre mantida
+    for i := 1; i < len(entradas); i++ {
+        if entradas[i].Evento.Zona != entradas[i - 1].Evento.Zona + 1 {
+            t.Errorf("Entries out of order %+v", entradas)
+        }
+    }
+    if entradas[len(entradas) - 1].Evento.Zona != 9 {
+        t.Errorf("Last entry missing %+v", entradas)
+    }
+
+    entradas, _ = ConsultarJournal(caminho, FiltroJournal{Codigo: -1, Zona: 9})
+    if len(entradas) != 1 {
+        t.Errorf("Zone filter failed %+v", entradas)
+    }
+    entradas, _ = ConsultarJournal(caminho, FiltroJournal{Codigo: -1, Zona: -1, Desde: inicio.Add(8 * time.Second)})
+    if len(entradas) != 2 {
+        t.Errorf("Time filter failed %+v", entradas)
+    }
+    entradas, _ = ConsultarJournal(caminho, FiltroJournal{Codigo: -1, Zona: -1, Mac: "aa:bb:dd"})
+    if len(entradas) != 0 {
+        t.Errorf("Central filter failed %+v", entradas)
+    }
+
+    // linha truncada é ignorada
+    f, _ := os.OpenFile(caminho, os.O_WRONLY | os.O_APPEND, 0644)
+    f.WriteString("{\"quando\":")
+    f.Close()
+    if _, err := ConsultarJournal(caminho, FiltroJournal{Codigo: -1, Zona: -1}); err != nil {
+        t.Errorf("Truncated line not tolerated: %v", err)
+    }
+}
+
+func TestJournalReceptor(t *testing.T) {
+    caminho := filepath.Join(t.TempDir(), "journal.jsonl")
+    r := receptorteste(t, "journal = " + caminho + "\njanela_dedup = 60\n")
+    nulo := func(int, []byte) (int, []byte) { return 0, nil }
+
+    c := centralfake_receptor(t, r, []byte{0xaa, 0xbb, 0x10}, nulo)
+    c.Write([]byte{0xf7})
+    c.Write(pacoteevento(130, 1, 5))
+    c.Write(pacoteevento(130, 1, 5))
+    c.Write(pacoteevento(401, 2, 7))
+
+    var entradas []EntradaJournal
+    for range 100 {
+        entradas, _ = ConsultarJournal(caminho, FiltroJournal{Codigo: -1, Zona: -1})
+        if len(entradas) >= 5 {
+            break
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    // identificação, heartbeat e 3 eventos
+    if len(entradas) != 5 {
+        t.Fatalf("Unexpected journal %+v", entradas)
+    }
+    if entradas[0].Tipo != 0x94 || entradas[0].Mac != "aa:bb:10" || entradas[1].Tipo != 0xf7 {
+        t.Errorf("Unexpected frames %+v", entradas[:2])
+    }
+    if entradas[2].Hex != hex.EncodeToString(pacoteevento(130, 1, 5)) || entradas[2].Evento == nil {
+        t.Errorf("Event not decoded %+v", entradas[2])
+    }
+
+    eventos, _ := ConsultarJournal(caminho, FiltroJournal{Codigo: 130, Zona: -1, Conta: 1234})
+    if len(eventos) != 2 || eventos[0].Duplicado || !eventos[1].Duplicado {
+        t.Errorf("Unexpected events %+v", eventos)
+    }
+    eventos, _ = ConsultarJournal(caminho, FiltroJournal{Codigo: -1, Zona: 7})
+    if len(eventos) != 1 || eventos[0].Evento.Codigo != 401 || eventos[0].Evento.Particao != 2 {
+        t.Errorf("Unexpected events %+v", eventos)
+    }
+}
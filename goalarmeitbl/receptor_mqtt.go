@@ -0,0 +1,478 @@
+package goalarmeitbl
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "regexp"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Publicação de eventos e estado das centrais num broker MQTT, com discovery do Home Assistant.
+// É um consumidor de eventos (ConsumidorEventos), portanto os eventos de uma central são
+// tratados em ordem. O estado das partições e zonas é deduzido dos códigos Contact ID.
+//
+// Tópicos, com <base> = <prefixo>/<mac sem ':'>, e.g. alarmeitbl/aabbcc:
+//   <prefixo>/status                 online/offline (disponibilidade do receptor)
+//   <base>/evento                    DadosGancho em JSON, a cada evento
+//   <base>/particao/<p>/estado       disarmed, armed_away, armed_home, triggered
+//   <base>/zona/<z>/aberta           ON/OFF, zona violada
+//   <base>/zona/<z>/disparo          ON/OFF, disparo da zona até a desativação da partição
+//   <base>/energia_ac                ON/OFF
+//   <base>/bateria_baixa             ON/OFF
+//
+// Tópicos de comando, assinados se habilitados, e respectivos tópicos de resultado, que recebem
+// ResultadoComandoMQTT em JSON:
+//   <base>/particao/<p>/comando      ARM_AWAY, ARM_HOME, DISARM, DESLIGAR_SIRENE
+//   <base>/zona/<z>/comando          BYPASS, CANCELAR_BYPASS
+//   <base>/comando                   DESLIGAR_SIRENE, LIMPAR_DISPARO (todas as partições)
+//   <base>/.../resultado             resultado do comando publicado no tópico .../comando
+
+type ConfigMQTT struct {
+    Broker string      // e.g. tcp://127.0.0.1:1883, "" = desabilitado
+    Usuario string
+    Senha string
+    Cliente string     // client ID, "" = gerado a partir do prefixo
+    Prefixo string
+    Discovery string   // prefixo de discovery do Home Assistant, "" = sem discovery
+    Comandos bool      // assina os tópicos de comando
+}
+
+const (
+    EstadoDesarmada = "disarmed"
+    EstadoArmada = "armed_away"
+    EstadoArmadaStay = "armed_home"
+    EstadoDisparada = "triggered"
+)
+
+// Comandos aceitos nos tópicos de comando; os de partição são os payloads default do Home Assistant
+const (
+    ComandoMQTTArmar = "ARM_AWAY"
+    ComandoMQTTArmarStay = "ARM_HOME"
+    ComandoMQTTDesarmar = "DISARM"
+    ComandoMQTTDesligarSirene = "DESLIGAR_SIRENE"
+    ComandoMQTTLimparDisparo = "LIMPAR_DISPARO"
+    ComandoMQTTBypass = "BYPASS"
+    ComandoMQTTCancelarBypass = "CANCELAR_BYPASS"
+)
+
+var ErrMQTTTimeout = errors.New("timeout de publicação MQTT")
+
+// Executa uma sequência de comandos na central identificada pelo MAC; fornecido pelo receptor
+type ExecutorComandoMQTT func(mac string, subs ...ComandoCentralSub) (RelatorioComando, error)
+
+// Publicado no tópico de resultado após cada comando
+type ResultadoComandoMQTT struct {
+    Topico string `json:"topico"`
+    Comando string `json:"comando"`
+    Relatorio RelatorioComando `json:"relatorio"`
+}
+
+type zonaMQTT struct {
+    particao int
+    disparo bool
+}
+
+type estadoCentralMQTT struct {
+    zonas map[int]*zonaMQTT
+    anunciados map[string]bool // entidades com discovery já publicado
+}
+
+type PublicadorMQTT struct {
+    cfg ConfigMQTT
+    cliente mqtt.Client
+    timeout time.Duration
+    executor ExecutorComandoMQTT // nil = comandos desabilitados
+
+    mutex sync.Mutex
+    centrais map[string]*estadoCentralMQTT
+}
+
+// Mensagem a publicar, retida no broker ou não
+type mensagemMQTT struct {
+    topico string
+    retida bool
+    payload []byte
+    anuncio string // entidade anunciada (discovery), marcada apenas após publicação bem-sucedida
+}
+
+// Inicia a conexão com o broker, que é refeita automaticamente se cair.
+// Não bloqueia: eventos anteriores à conexão são publicados assim que ela se estabelece
+// Se executor não for nil, assina os tópicos de comando a cada (re)conexão
+func NewPublicadorMQTT(cfg ConfigMQTT, executor ExecutorComandoMQTT) *PublicadorMQTT {
+    p := new(PublicadorMQTT)
+    p.cfg = cfg
+    p.executor = executor
+    p.timeout = 10 * time.Second
+    p.centrais = make(map[string]*estadoCentralMQTT)
+
+    cliente := cfg.Cliente
+    if cliente == "" {
+        cliente = fmt.Sprintf("%s-%d", cfg.Prefixo, time.Now().UnixNano())
+    }
+    opcoes := mqtt.NewClientOptions().AddBroker(cfg.Broker).SetClientID(cliente).
+        SetUsername(cfg.Usuario).SetPassword(cfg.Senha).
+        SetAutoReconnect(true).SetConnectRetry(true).SetConnectRetryInterval(5 * time.Second).
+        SetWill(p.topico_status(), "offline", 1, true).
+        SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+            fmt.Println("PublicadorMQTT: conexão perdida:", err)
+        }).
+        SetOnConnectHandler(func(c mqtt.Client) {
+            fmt.Println("PublicadorMQTT: conectado a", cfg.Broker)
+            c.Publish(p.topico_status(), 1, true, "online")
+            if p.executor != nil {
+                p.assinar_comandos(c)
+            }
+        })
+    p.cliente = mqtt.NewClient(opcoes)
+    p.cliente.Connect()
+    return p
+}
+
+func (p *PublicadorMQTT) Close() {
+    p.cliente.Publish(p.topico_status(), 1, true, "offline").WaitTimeout(p.timeout)
+    p.cliente.Disconnect(250)
+}
+
+func (p *PublicadorMQTT) topico_status() string {
+    return p.cfg.Prefixo + "/status"
+}
+
+func id_mqtt(mac string) string {
+    return strings.ReplaceAll(mac, ":", "")
+}
+
+func (p *PublicadorMQTT) base(mac string) string {
+    return p.cfg.Prefixo + "/" + id_mqtt(mac)
+}
+
+func on_off(b bool) string {
+    if b {
+        return "ON"
+    }
+    return "OFF"
+}
+
+// Implementa ConsumidorEventos
+func (p *PublicadorMQTT) Consumir(dados DadosGancho) error {
+    if dados.Tipo != "ev" || dados.Mac == "" || dados.Evento == nil || !dados.Evento.Valido {
+        return nil
+    }
+
+    evento, err := json.Marshal(dados)
+    if err != nil {
+        return err
+    }
+    mensagens := []mensagemMQTT{{p.base(dados.Mac) + "/evento", false, evento, ""}}
+    mensagens = append(mensagens, p.atualizar_estado(dados.Mac, *dados.Evento)...)
+
+    erros := []error{}
+    for _, m := range mensagens {
+        err := p.publicar(m)
+        if err == nil && m.anuncio != "" {
+            p.marcar_anunciado(dados.Mac, m.anuncio)
+        }
+        erros = append(erros, err)
+    }
+    return errors.Join(erros...)
+}
+
+// Registra o discovery da entidade como publicado; se a publicação falhar, é repetido no próximo evento
+func (p *PublicadorMQTT) marcar_anunciado(mac string, id string) {
+    p.mutex.Lock()
+    defer p.mutex.Unlock()
+    if central, ok := p.centrais[mac]; ok {
+        central.anunciados[id] = true
+    }
+}
+
+func (p *PublicadorMQTT) publicar(m mensagemMQTT) error {
+    token := p.cliente.Publish(m.topico, 1, m.retida, m.payload)
+    if !token.WaitTimeout(p.timeout) {
+        return fmt.Errorf("%w: %s", ErrMQTTTimeout, m.topico)
+    }
+    return token.Error()
+}
+
+// Entidade do Home Assistant correspondente a um tópico de estado
+type entidadeMQTT struct {
+    id string          // sufixo do unique_id, e.g. "p1", "z5_aberta"
+    componente string  // alarm_control_panel ou binary_sensor
+    nome string
+    topico string      // relativo à base da central
+    classe string      // device_class
+    acao string        // payload do botão (componente button)
+}
+
+func entidade_particao(particao int) entidadeMQTT {
+    return entidadeMQTT{fmt.Sprintf("p%d", particao), "alarm_control_panel", fmt.Sprintf("Partição %d", particao),
+        fmt.Sprintf("/particao/%d/estado", particao), "", ""}
+}
+
+func entidade_zona_aberta(zona int) entidadeMQTT {
+    return entidadeMQTT{fmt.Sprintf("z%d_aberta", zona), "binary_sensor", fmt.Sprintf("Zona %d", zona),
+        fmt.Sprintf("/zona/%d/aberta", zona), "opening", ""}
+}
+
+func entidade_zona_disparo(zona int) entidadeMQTT {
+    return entidadeMQTT{fmt.Sprintf("z%d_disparo", zona), "binary_sensor", fmt.Sprintf("Zona %d disparo", zona),
+        fmt.Sprintf("/zona/%d/disparo", zona), "safety", ""}
+}
+
+var entidadeEnergiaAC = entidadeMQTT{"energia_ac", "binary_sensor", "Energia AC", "/energia_ac", "power", ""}
+var entidadeBateriaBaixa = entidadeMQTT{"bateria_baixa", "binary_sensor", "Bateria baixa", "/bateria_baixa", "battery", ""}
+
+// Botões anunciados para cada central, se os comandos estiverem habilitados
+var botoesMQTT = []entidadeMQTT{
+    {"desligar_sirene", "button", "Desligar sirene", "/comando", "", ComandoMQTTDesligarSirene},
+    {"limpar_disparo", "button", "Limpar disparo", "/comando", "", ComandoMQTTLimparDisparo},
+}
+
+// Deduz o novo estado a partir do evento, retornando as mensagens retidas de estado,
+// precedidas do discovery das entidades ainda não anunciadas
+func (p *PublicadorMQTT) atualizar_estado(mac string, evento RIPAlarme) []mensagemMQTT {
+    p.mutex.Lock()
+    defer p.mutex.Unlock()
+
+    mensagens := []mensagemMQTT{}
+    central, ok := p.centrais[mac]
+    if !ok {
+        central = &estadoCentralMQTT{make(map[int]*zonaMQTT), make(map[string]bool)}
+        p.centrais[mac] = central
+        if p.executor != nil {
+            for _, botao := range botoesMQTT {
+                mensagens = append(mensagens, p.discovery(central, mac, botao)...)
+            }
+        }
+    }
+    estado := func(entidade entidadeMQTT, valor string) {
+        mensagens = append(mensagens, p.discovery(central, mac, entidade)...)
+        mensagens = append(mensagens, mensagemMQTT{p.base(mac) + entidade.topico, true, []byte(valor), ""})
+    }
+    particao := entidade_particao(evento.Particao)
+    abertura := evento.Qualificador == 1
+    restauro := evento.Qualificador == 3
+
+    desarmar := func() {
+        estado(particao, EstadoDesarmada)
+        for z, zona := range central.zonas {
+            if zona.disparo && (evento.Particao == 0 || zona.particao == evento.Particao) {
+                zona.disparo = false
+                estado(entidade_zona_disparo(z), "OFF")
+            }
+        }
+    }
+
+    switch evento.Codigo {
+    case 401, 403, 404, 407:
+        // ativação (restauro) ou desativação (abertura)
+        if restauro {
+            estado(particao, EstadoArmada)
+        } else if abertura {
+            desarmar()
+        }
+    case 408:
+        estado(particao, EstadoArmada)
+    case 441, 456:
+        // ativação stay / parcial
+        if restauro {
+            estado(particao, EstadoArmadaStay)
+        } else if abertura {
+            desarmar()
+        }
+    case 130, 133, 146:
+        if evento.Zona > 0 {
+            zona, ok := central.zonas[evento.Zona]
+            if !ok {
+                zona = &zonaMQTT{}
+                central.zonas[evento.Zona] = zona
+            }
+            zona.particao = evento.Particao
+            estado(entidade_zona_aberta(evento.Zona), on_off(!restauro))
+            if !restauro {
+                zona.disparo = true
+                estado(entidade_zona_disparo(evento.Zona), "ON")
+            }
+        }
+        if !restauro {
+            estado(particao, EstadoDisparada)
+        }
+    case 100, 110, 120, 122:
+        estado(particao, EstadoDisparada)
+    case 301:
+        // abertura = falta de energia
+        estado(entidadeEnergiaAC, on_off(!abertura))
+    case 302:
+        estado(entidadeBateriaBaixa, on_off(abertura))
+    }
+
+    return mensagens
+}
+
+// Mensagem de discovery do Home Assistant para a entidade, se ainda não anunciada
+func (p *PublicadorMQTT) discovery(central *estadoCentralMQTT, mac string, entidade entidadeMQTT) []mensagemMQTT {
+    if p.cfg.Discovery == "" || central.anunciados[entidade.id] {
+        return nil
+    }
+
+    id := "alarmeitbl_" + id_mqtt(mac) + "_" + entidade.id
+    config := map[string]any{
+        "name": entidade.nome,
+        "unique_id": id,
+        "object_id": id,
+        "availability_topic": p.topico_status(),
+        "device": map[string]any{
+            "identifiers": []string{"alarmeitbl_" + id_mqtt(mac)},
+            "name": "Central de alarme " + mac,
+            "manufacturer": "Intelbras",
+        },
+    }
+    if entidade.classe != "" {
+        config["device_class"] = entidade.classe
+    }
+    if entidade.componente == "button" {
+        config["command_topic"] = p.base(mac) + entidade.topico
+        config["payload_press"] = entidade.acao
+    } else {
+        config["state_topic"] = p.base(mac) + entidade.topico
+    }
+    if entidade.componente == "alarm_control_panel" && p.executor != nil {
+        // sem comandos, o painel é apenas de leitura
+        config["command_topic"] = p.base(mac) + strings.TrimSuffix(entidade.topico, "/estado") + "/comando"
+        config["code_arm_required"] = false
+        config["supported_features"] = []string{"arm_away", "arm_home"}
+    }
+
+    payload, _ := json.Marshal(config)
+    return []mensagemMQTT{{fmt.Sprintf("%s/%s/%s/config", p.cfg.Discovery, entidade.componente, id), true, payload, entidade.id}}
+}
+
+func (p *PublicadorMQTT) assinar_comandos(c mqtt.Client) {
+    filtros := map[string]byte{
+        p.cfg.Prefixo + "/+/comando": 1,
+        p.cfg.Prefixo + "/+/particao/+/comando": 1,
+        p.cfg.Prefixo + "/+/zona/+/comando": 1,
+    }
+    token := c.SubscribeMultiple(filtros, p.receber_comando)
+    go func() {
+        if token.WaitTimeout(p.timeout) && token.Error() != nil {
+            fmt.Println("PublicadorMQTT: falha ao assinar comandos:", token.Error())
+        }
+    }()
+}
+
+// Invocado pelo cliente MQTT; o comando pode demorar, portanto é executado em goroutine própria
+func (p *PublicadorMQTT) receber_comando(_ mqtt.Client, msg mqtt.Message) {
+    if msg.Retained() {
+        // um comando retido seria repetido a cada conexão
+        fmt.Println("PublicadorMQTT: comando retido ignorado em", msg.Topic())
+        return
+    }
+    go p.executar_comando(msg.Topic(), string(msg.Payload()))
+}
+
+func (p *PublicadorMQTT) executar_comando(topico string, comando string) {
+    comando = strings.ToUpper(strings.TrimSpace(comando))
+    fmt.Printf("PublicadorMQTT: comando %s em %s\n", comando, topico)
+
+    var relatorio RelatorioComando
+    mac, subs, err := p.traduzir_comando(topico, comando)
+    if err == nil {
+        relatorio, err = p.executor(mac, subs...)
+    }
+    if err != nil {
+        relatorio.Sucesso = false
+        if relatorio.Erro == "" {
+            relatorio.Erro = err.Error()
+        }
+        fmt.Printf("PublicadorMQTT: comando %s em %s falhou: %v\n", comando, topico, err)
+    }
+
+    resultado, _ := json.Marshal(ResultadoComandoMQTT{topico, comando, relatorio})
+    topico_resultado := strings.TrimSuffix(topico, "comando") + "resultado"
+    if err := p.publicar(mensagemMQTT{topico_resultado, false, resultado, ""}); err != nil {
+        fmt.Println("PublicadorMQTT: falha ao publicar resultado:", err)
+    }
+}
+
+var formatoIdMQTT = regexp.MustCompile("^[0-9a-f]{6}$")
+
+// Traduz tópico e comando em MAC da central e sequência de comandos
+func (p *PublicadorMQTT) traduzir_comando(topico string, comando string) (string, []ComandoCentralSub, error) {
+    partes := strings.Split(strings.TrimPrefix(topico, p.cfg.Prefixo + "/"), "/")
+    id := strings.ToLower(partes[0])
+    if !formatoIdMQTT.MatchString(id) {
+        return "", nil, fmt.Errorf("central inválida %s", partes[0])
+    }
+    mac := id[0:2] + ":" + id[2:4] + ":" + id[4:6]
+    invalido := fmt.Errorf("comando %s inválido em %s", comando, topico)
+
+    numero := func(min int, max int) (int, error) {
+        n, err := strconv.Atoi(partes[2])
+        if err != nil || n < min || n > max {
+            return 0, fmt.Errorf("%s %s inválida", partes[1], partes[2])
+        }
+        return n, nil
+    }
+
+    switch {
+    case len(partes) == 2:
+        switch comando {
+        case ComandoMQTTDesligarSirene:
+            sub, _ := NewDesligarSirene([]any{0})
+            return mac, []ComandoCentralSub{sub}, nil
+        case ComandoMQTTLimparDisparo:
+            sub, _ := NewLimparDisparo(nil)
+            return mac, []ComandoCentralSub{sub}, nil
+        }
+    case len(partes) == 4 && partes[1] == "particao":
+        particao, err := numero(0, 255)
+        if err != nil {
+            return mac, nil, err
+        }
+        switch comando {
+        case ComandoMQTTArmar:
+            return mac, []ComandoCentralSub{NewArmarCentral(particao, ModoArmar)}, nil
+        case ComandoMQTTArmarStay:
+            return mac, []ComandoCentralSub{NewArmarCentral(particao, ModoStay)}, nil
+        case ComandoMQTTDesarmar:
+            return mac, []ComandoCentralSub{NewArmarCentral(particao, ModoDesarmar)}, nil
+        case ComandoMQTTDesligarSirene:
+            sub, _ := NewDesligarSirene([]any{particao})
+            return mac, []ComandoCentralSub{sub}, nil
+        }
+    case len(partes) == 4 && partes[1] == "zona":
+        zona, err := numero(1, 254)
+        if err != nil {
+            return mac, nil, err
+        }
+        if comando == ComandoMQTTBypass || comando == ComandoMQTTCancelarBypass {
+            sub, errstring := NewBypassZonas(ListaZonas{false, []int{zona}}, comando == ComandoMQTTBypass)
+            if errstring != "" {
+                return mac, nil, errors.New(errstring)
+            }
+            return mac, []ComandoCentralSub{sub}, nil
+        }
+    }
+    return mac, nil, invalido
+}
+
+// Executor de comandos MQTT do receptor: através da conexão existente com a central,
+// com as credenciais configuradas, nas mesmas condições da API de controle
+func (r *ReceptorIP) executar_comando_mqtt(mac string, subs ...ComandoCentralSub) (RelatorioComando, error) {
+    if !r.central_conectada(mac) {
+        return RelatorioComando{}, ErrCentralDesconectada
+    }
+    cred := Credenciais{r.cfg.Senha, r.cfg.TamSenha}
+    if cred.TamSenha != 4 && cred.TamSenha != 6 {
+        return RelatorioComando{}, errors.New("senha não configurada ou tamanho de senha inválido")
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), timeoutComandoControle)
+    defer cancel()
+    return r.Executar(ctx, mac, cred, PoliticaRetryDefault, subs...)
+}
@@ -0,0 +1,447 @@
+package goalarmeitbl
+
+import (
+    "testing"
+    "bufio"
+    "encoding/binary"
+    "encoding/json"
+    "io"
+    "net"
+    "slices"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Broker MQTT 3.1.1 mínimo para testes: aceita conexões, publicações e assinaturas, guardando a última
+// mensagem de cada tópico. Entrega sempre com QoS 0
+type BrokerFake struct {
+    addr string
+    mutex sync.Mutex
+    mensagens map[string][]byte
+    retidas map[string][]byte
+    assinaturas map[*conexaoBrokerFake][]string
+}
+
+type conexaoBrokerFake struct {
+    c net.Conn
+    mutex sync.Mutex // escrita
+}
+
+func (cb *conexaoBrokerFake) escrever(pacote []byte) {
+    cb.mutex.Lock()
+    defer cb.mutex.Unlock()
+    cb.c.Write(pacote)
+}
+
+// Testa se o tópico corresponde ao filtro, com curingas + e #
+func filtro_mqtt(filtro string, topico string) bool {
+    f := strings.Split(filtro, "/")
+    tp := strings.Split(topico, "/")
+    for i, nivel := range f {
+        if nivel == "#" {
+            return true
+        }
+        if i >= len(tp) || (nivel != "+" && nivel != tp[i]) {
+            return false
+        }
+    }
+    return len(f) == len(tp)
+}
+
+func brokerfake(t *testing.T) *BrokerFake {
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { l.Close() })
+    b := &BrokerFake{addr: "tcp://" + l.Addr().String(), mensagens: make(map[string][]byte),
+        retidas: make(map[string][]byte), assinaturas: make(map[*conexaoBrokerFake][]string)}
+    go func() {
+        for {
+            c, err := l.Accept()
+            if err != nil {
+                return
+            }
+            t.Cleanup(func() { c.Close() })
+            go b.conexao(c)
+        }
+    }()
+    return b
+}
+
+func (b *BrokerFake) conexao(c net.Conn) {
+    cb := &conexaoBrokerFake{c: c}
+    defer func() {
+        b.mutex.Lock()
+        delete(b.assinaturas, cb)
+        b.mutex.Unlock()
+        c.Close()
+    }()
+    r := bufio.NewReader(c)
+    for {
+        cabecalho, err := r.ReadByte()
+        if err != nil {
+            return
+        }
+        tamanho, err := binary.ReadUvarint(r) // mesma codificação do "remaining length"
+        if err != nil {
+            return
+        }
+        corpo := make([]byte, tamanho)
+        if _, err := io.ReadFull(r, corpo); err != nil {
+            return
+        }
+
+        switch cabecalho >> 4 {
+        case 1: // CONNECT
+            cb.escrever([]byte{0x20, 2, 0, 0})
+        case 3: // PUBLISH
+            qos := (cabecalho >> 1) & 3
+            n := int(binary.BigEndian.Uint16(corpo))
+            topico := string(corpo[2:2 + n])
+            payload := corpo[2 + n:]
+            if qos > 0 {
+                cb.escrever([]byte{0x40, 2, payload[0], payload[1]})
+                payload = payload[2:]
+            }
+            b.publicar(topico, payload, cabecalho & 1 != 0)
+        case 8: // SUBSCRIBE
+            filtros := []string{}
+            suback := []byte{corpo[0], corpo[1]}
+            for resto := corpo[2:]; len(resto) > 0; {
+                n := int(binary.BigEndian.Uint16(resto))
+                filtros = append(filtros, string(resto[2:2 + n]))
+                resto = resto[3 + n:]
+                suback = append(suback, 0)
+            }
+            cb.escrever(append([]byte{0x90, byte(len(suback))}, suback...))
+            b.mutex.Lock()
+            b.assinaturas[cb] = append(b.assinaturas[cb], filtros...)
+            b.mutex.Unlock()
+        case 12: // PINGREQ
+            cb.escrever([]byte{0xd0, 0})
+        case 14: // DISCONNECT
+            return
+        }
+    }
+}
+
+// Registra a mensagem e a entrega aos assinantes
+func (b *BrokerFake) publicar(topico string, payload []byte, retida bool) {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    b.mensagens[topico] = payload
+    if retida {
+        b.retidas[topico] = payload
+    }
+
+    var flags byte = 0x30
+    if retida {
+        flags |= 1
+    }
+    corpo := slices.Concat(binary.BigEndian.AppendUint16(nil, uint16(len(topico))), []byte(topico), payload)
+    pacote := slices.Concat([]byte{flags}, binary.AppendUvarint(nil, uint64(len(corpo))), corpo)
+    for cb, filtros := range b.assinaturas {
+        if slices.ContainsFunc(filtros, func(f string) bool { return filtro_mqtt(f, topico) }) {
+            cb.escrever(pacote)
+        }
+    }
+}
+
+// Aguarda alguma conexão assinar o filtro
+func (b *BrokerFake) aguardar_assinatura(t *testing.T, filtro string) {
+    t.Helper()
+    for range 200 {
+        b.mutex.Lock()
+        for _, filtros := range b.assinaturas {
+            if slices.Contains(filtros, filtro) {
+                b.mutex.Unlock()
+                return
+            }
+        }
+        b.mutex.Unlock()
+        time.Sleep(10 * time.Millisecond)
+    }
+    t.Fatalf("Filter %s not subscribed", filtro)
+}
+
+// Aguarda mensagem no tópico com o conteúdo informado, ou qualquer conteúdo se vazio
+func (b *BrokerFake) aguardar(t *testing.T, topico string, esperado string) []byte {
+    t.Helper()
+    for range 200 {
+        b.mutex.Lock()
+        payload, ok := b.mensagens[topico]
+        b.mutex.Unlock()
+        if ok && (esperado == "" || string(payload) == esperado) {
+            return payload
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    t.Fatalf("Topic %s did not receive %q", topico, esperado)
+    return nil
+}
+
+func (b *BrokerFake) retida(topico string) (string, bool) {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    payload, ok := b.retidas[topico]
+    return string(payload), ok
+}
+
+func TestMQTTEstado(t *testing.T) {
+    p := &PublicadorMQTT{cfg: ConfigMQTT{Prefixo: "alarme", Discovery: "ha"}, centrais: make(map[string]*estadoCentralMQTT)}
+    p.executor = func(string, ...ComandoCentralSub) (RelatorioComando, error) { return RelatorioComando{}, nil }
+    // simula publicação bem-sucedida
+    estados := func(mensagens []mensagemMQTT) map[string]string {
+        m := make(map[string]string)
+        for _, msg := range mensagens {
+            if !msg.retida {
+                t.Errorf("State message not retained %s", msg.topico)
+            }
+            m[msg.topico] = string(msg.payload)
+            if msg.anuncio != "" {
+                p.marcar_anunciado("aa:bb:01", msg.anuncio)
+            }
+        }
+        return m
+    }
+
+    // disparo da zona 5 na partição 1
+    m := estados(p.atualizar_estado("aa:bb:01", RIPAlarme{Valido: true, Codigo: 130, Qualificador: 1, Particao: 1, Zona: 5}))
+    if m["alarme/aabb01/zona/5/aberta"] != "ON" || m["alarme/aabb01/zona/5/disparo"] != "ON" ||
+            m["alarme/aabb01/particao/1/estado"] != EstadoDisparada {
+        t.Errorf("Unexpected state %v", m)
+    }
+    var painel map[string]any
+    if err := json.Unmarshal([]byte(m["ha/alarm_control_panel/alarmeitbl_aabb01_p1/config"]), &painel); err != nil {
+        t.Fatal(err)
+    }
+    if painel["state_topic"] != "alarme/aabb01/particao/1/estado" || painel["command_topic"] != "alarme/aabb01/particao/1/comando" ||
+            painel["availability_topic"] != "alarme/status" {
+        t.Errorf("Unexpected discovery %v", painel)
+    }
+    var sensor map[string]any
+    if err := json.Unmarshal([]byte(m["ha/binary_sensor/alarmeitbl_aabb01_z5_aberta/config"]), &sensor); err != nil {
+        t.Fatal(err)
+    }
+    if sensor["state_topic"] != "alarme/aabb01/zona/5/aberta" || sensor["device_class"] != "opening" {
+        t.Errorf("Unexpected discovery %v", sensor)
+    }
+
+    // restauro da zona, discovery não é repetido
+    m = estados(p.atualizar_estado("aa:bb:01", RIPAlarme{Valido: true, Codigo: 130, Qualificador: 3, Particao: 1, Zona: 5}))
+    if len(m) != 1 || m["alarme/aabb01/zona/5/aberta"] != "OFF" {
+        t.Errorf("Unexpected state %v", m)
+    }
+
+    // desativação limpa o disparo
+    m = estados(p.atualizar_estado("aa:bb:01", RIPAlarme{Valido: true, Codigo: 401, Qualificador: 1, Particao: 1}))
+    if m["alarme/aabb01/particao/1/estado"] != EstadoDesarmada || m["alarme/aabb01/zona/5/disparo"] != "OFF" {
+        t.Errorf("Unexpected state %v", m)
+    }
+
+    m = estados(p.atualizar_estado("aa:bb:01", RIPAlarme{Valido: true, Codigo: 441, Qualificador: 3, Particao: 2}))
+    if m["alarme/aabb01/particao/2/estado"] != EstadoArmadaStay {
+        t.Errorf("Unexpected state %v", m)
+    }
+    m = estados(p.atualizar_estado("aa:bb:01", RIPAlarme{Valido: true, Codigo: 401, Qualificador: 3, Particao: 1}))
+    if len(m) != 1 || m["alarme/aabb01/particao/1/estado"] != EstadoArmada {
+        t.Errorf("Unexpected state %v", m)
+    }
+    m = estados(p.atualizar_estado("aa:bb:01", RIPAlarme{Valido: true, Codigo: 301, Qualificador: 1}))
+    if m["alarme/aabb01/energia_ac"] != "OFF" {
+        t.Errorf("Unexpected state %v", m)
+    }
+
+    // evento sem efeito sobre o estado
+    if m := p.atualizar_estado("aa:bb:01", RIPAlarme{Valido: true, Codigo: 602, Qualificador: 1}); len(m) != 0 {
+        t.Errorf("Unexpected state %v", m)
+    }
+}
+
+func TestMQTTDiscovery(t *testing.T) {
+    p := &PublicadorMQTT{cfg: ConfigMQTT{Prefixo: "alarme", Discovery: "ha"}, centrais: make(map[string]*estadoCentralMQTT)}
+    topico := "ha/alarm_control_panel/alarmeitbl_aabb02_p1/config"
+    evento := RIPAlarme{Valido: true, Codigo: 401, Qualificador: 3, Particao: 1}
+
+    // sem comandos, painel apenas de leitura
+    var painel map[string]any
+    for _, msg := range p.atualizar_estado("aa:bb:02", evento) {
+        if msg.topico == topico {
+            json.Unmarshal(msg.payload, &painel)
+        }
+    }
+    if painel == nil || painel["state_topic"] != "alarme/aabb02/particao/1/estado" {
+        t.Fatalf("Unexpected discovery %v", painel)
+    }
+    if _, ok := painel["command_topic"]; ok {
+        t.Errorf("Command topic announced without commands %v", painel)
+    }
+    if _, ok := painel["supported_features"]; ok {
+        t.Errorf("Features announced without commands %v", painel)
+    }
+
+    // discovery não publicado é repetido no evento seguinte
+    repetido := false
+    for _, msg := range p.atualizar_estado("aa:bb:02", evento) {
+        if msg.topico == topico && msg.anuncio == "p1" {
+            repetido = true
+            p.marcar_anunciado("aa:bb:02", msg.anuncio)
+        }
+    }
+    if !repetido {
+        t.Error("Unpublished discovery should be repeated")
+    }
+    if m := p.atualizar_estado("aa:bb:02", evento); len(m) != 1 {
+        t.Errorf("Published discovery should not be repeated %v", m)
+    }
+}
+
+func TestMQTTReceptor(t *testing.T) {
+    b := brokerfake(t)
+    r := receptorteste(t, "[mqtt]\nbroker = " + b.addr + "\nprefixo = alarme\n")
+    b.aguardar(t, "alarme/status", "online")
+
+    c := centralfake_receptor(t, r, []byte{0xaa, 0xbb, 0x08}, func(int, []byte) (int, []byte) {
+        return 0, nil
+    })
+    c.Write(pacoteevento(130, 1, 5))
+    b.aguardar(t, "alarme/aabb08/zona/5/disparo", "ON")
+    c.Write(pacoteevento(401, 1, 0))
+    b.aguardar(t, "alarme/aabb08/particao/1/estado", EstadoDesarmada)
+
+    if v, _ := b.retida("alarme/aabb08/zona/5/disparo"); v != "OFF" {
+        t.Errorf("Trigger not cleared: %s", v)
+    }
+    if _, ok := b.retida("homeassistant/alarm_control_panel/alarmeitbl_aabb08_p1/config"); !ok {
+        t.Error("Discovery not published")
+    }
+    var dados DadosGancho
+    if err := json.Unmarshal(b.aguardar(t, "alarme/aabb08/evento", ""), &dados); err != nil || dados.Mac != "aa:bb:08" ||
+            dados.Evento == nil {
+        t.Errorf("Unexpected event %+v", dados)
+    }
+}
+
+func TestMQTTTraducaoComandos(t *testing.T) {
+    p := &PublicadorMQTT{cfg: ConfigMQTT{Prefixo: "alarme"}}
+
+    mac, subs, err := p.traduzir_comando("alarme/AABB01/particao/2/comando", ComandoMQTTArmarStay)
+    if err != nil || mac != "aa:bb:01" || len(subs) != 1 {
+        t.Fatalf("Unexpected translation %s %v %v", mac, subs, err)
+    }
+    if armar, ok := subs[0].(*ArmarCentral); !ok || armar.particao != 2 || armar.modo != ModoStay {
+        t.Errorf("Unexpected command %+v", subs[0])
+    }
+
+    _, subs, _ = p.traduzir_comando("alarme/aabb01/particao/0/comando", ComandoMQTTDesarmar)
+    if armar, ok := subs[0].(*ArmarCentral); !ok || armar.particao != 0xff || armar.modo != ModoDesarmar {
+        t.Errorf("Unexpected command %+v", subs[0])
+    }
+    _, subs, _ = p.traduzir_comando("alarme/aabb01/zona/7/comando", ComandoMQTTCancelarBypass)
+    if bypass, ok := subs[0].(*BypassZona); !ok || bypass.bypass || !slices.Equal(bypass.zonas.Zonas, []int{7}) {
+        t.Errorf("Unexpected command %+v", subs[0])
+    }
+    _, subs, _ = p.traduzir_comando("alarme/aabb01/comando", ComandoMQTTDesligarSirene)
+    if sirene, ok := subs[0].(*DesligarSirene); !ok || sirene.particao != 0xff {
+        t.Errorf("Unexpected command %+v", subs[0])
+    }
+    _, subs, _ = p.traduzir_comando("alarme/aabb01/comando", ComandoMQTTLimparDisparo)
+    if _, ok := subs[0].(*LimparDisparo); !ok {
+        t.Errorf("Unexpected command %+v", subs[0])
+    }
+
+    for _, invalido := range [][]string{
+        {"alarme/aabb01/comando", ComandoMQTTArmar},
+        {"alarme/aabb01/particao/x/comando", ComandoMQTTArmar},
+        {"alarme/aabb01/zona/0/comando", ComandoMQTTBypass},
+        {"alarme/aabb01/zona/3/comando", ComandoMQTTDesarmar},
+        {"alarme/central/particao/1/comando", ComandoMQTTArmar},
+    } {
+        if _, _, err := p.traduzir_comando(invalido[0], invalido[1]); err == nil {
+            t.Errorf("Should have failed: %v", invalido)
+        }
+    }
+}
+
+func TestMQTTComandos(t *testing.T) {
+    b := brokerfake(t)
+    r := receptorteste(t, "senha = 123456\ntamanho = 6\ncaddr = receptor\n" +
+        "[mqtt]\nbroker = " + b.addr + "\nprefixo = alarme\ncomandos = 1\n")
+    b.aguardar_assinatura(t, "alarme/+/particao/+/comando")
+
+    recebidos := make(chan []byte, 10)
+    centralfake_receptor(t, r, []byte{0xaa, 0xbb, 0x09}, func(cmd int, payload []byte) (int, []byte) {
+        if cmd == 0x401e {
+            recebidos <- payload
+            return 0x401e, nil
+        }
+        return 0, nil
+    })
+    aguardaregistro(t, r, "aa:bb:09", func(reg RegistroCentral) bool { return reg.Conectada })
+
+    resultado := func(topico string) ResultadoComandoMQTT {
+        var res ResultadoComandoMQTT
+        if err := json.Unmarshal(b.aguardar(t, topico, ""), &res); err != nil {
+            t.Fatal(err)
+        }
+        return res
+    }
+
+    b.publicar("alarme/aabb09/particao/1/comando", []byte("ARM_AWAY"), false)
+    res := resultado("alarme/aabb09/particao/1/resultado")
+    if !res.Relatorio.Sucesso || res.Comando != ComandoMQTTArmar || res.Topico != "alarme/aabb09/particao/1/comando" {
+        t.Errorf("Unexpected result %+v", res)
+    }
+    if payload := <-recebidos; !slices.Equal(payload, []byte{1, byte(ModoArmar)}) {
+        t.Errorf("Unexpected command payload %x", payload)
+    }
+
+    // comando retido é ignorado
+    b.publicar("alarme/aabb09/particao/2/comando", []byte("DISARM"), true)
+    b.publicar("alarme/aabb09/particao/3/comando", []byte("pular"), false)
+    res = resultado("alarme/aabb09/particao/3/resultado")
+    if res.Relatorio.Sucesso || res.Relatorio.Erro == "" || res.Comando != "PULAR" {
+        t.Errorf("Unexpected result %+v", res)
+    }
+    b.mutex.Lock()
+    _, ok := b.mensagens["alarme/aabb09/particao/2/resultado"]
+    b.mutex.Unlock()
+    if ok || len(recebidos) != 0 {
+        t.Error("Retained command should have been ignored")
+    }
+
+    b.publicar("alarme/aabb0a/comando", []byte("LIMPAR_DISPARO"), false)
+    if res := resultado("alarme/aabb0a/resultado"); res.Relatorio.Sucesso ||
+            res.Relatorio.Erro != ErrCentralDesconectada.Error() {
+        t.Errorf("Unexpected result %+v", res)
+    }
+}
+
+func TestConfigMQTT(t *testing.T) {
+    cfg, err := NewReceptorIPConfig(strings.NewReader("[receptorip]\n"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if cfg.MQTT.Broker != "" {
+        t.Error("MQTT should be disabled by default")
+    }
+
+    cfg, err = NewReceptorIPConfig(strings.NewReader("[receptorip]\n[mqtt]\nbroker = tcp://localhost:1883\n" +
+        "usuario = u\nsenha = s\nprefixo = /casa/alarme/\ndiscovery =\ncomandos = on\n"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    m := cfg.MQTT
+    if m.Broker != "tcp://localhost:1883" || m.Usuario != "u" || m.Senha != "s" || m.Prefixo != "casa/alarme" ||
+            m.Discovery != "" || !m.Comandos {
+        t.Errorf("Unexpected config %+v", m)
+    }
+
+    for _, invalido := range []string{"usuario = u\n", "broker = tcp://x\nprefixo = a/#\n", "broker = tcp://x\ncomandos = talvez\n"} {
+        if _, err := NewReceptorIPConfig(strings.NewReader("[receptorip]\n[mqtt]\n" + invalido)); err == nil {
+            t.Errorf("Should have failed: %s", invalido)
+        }
+    }
+}
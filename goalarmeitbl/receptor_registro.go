@@ -0,0 +1,137 @@
+package goalarmeitbl
+
+import (
+    "maps"
+    "slices"
+    "strings"
+    "time"
+)
+
+// Registro das centrais que se identificaram desde o início do receptor,
+// indexado pelo MAC. Acessado pelas goroutines dos tratadores, do túnel ISECNet2
+// e da API de controle, sempre sob ReceptorIP.mutex
+
+type RegistroCentral struct {
+    Conta int `json:"conta"`
+    Mac string `json:"mac"`
+    Canal string `json:"canal"`       // Ethernet, GPRS, GPRS2
+    Endereco string `json:"endereco"` // da sessão mais recente
+    Conectada bool `json:"conectada"`
+    Sessoes int `json:"sessoes"`      // total de sessões identificadas
+    SessoesAtivas int `json:"sessoes_ativas"`
+    Duplicadas int `json:"duplicadas"` // sessões abertas com outra já ativa
+    PrimeiroContato time.Time `json:"primeiro_contato"`
+    UltimoContato time.Time `json:"ultimo_contato"`
+    ConectadaDesde time.Time `json:"conectada_desde,omitzero"`
+    UltimoHeartbeat time.Time `json:"ultimo_heartbeat,omitzero"`
+    UltimoEvento time.Time `json:"ultimo_evento,omitzero"`
+    DescricaoUltimoEvento string `json:"descricao_ultimo_evento,omitempty"`
+
+    sessoes []*TratadorReceptorIP // sessões ativas, da mais antiga para a mais recente
+}
+
+// Registra sessão de central identificada, se o número máximo de conexões não foi atingido
+// Retorna também se a central já tinha outra sessão ativa
+// Invocado pelo tratador
+func (r *ReceptorIP) registra_central(t *TratadorReceptorIP) (bool, bool) {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+    if r.centrais_identificadas >= r.cfg.MaxConn {
+        return false, false
+    }
+    r.centrais_identificadas += 1
+
+    agora := time.Now()
+    reg, ok := r.registro[t.macaddr]
+    if !ok {
+        reg = &RegistroCentral{Mac: t.macaddr, PrimeiroContato: agora}
+        r.registro[t.macaddr] = reg
+    }
+    duplicada := len(reg.sessoes) > 0
+    if duplicada {
+        reg.Duplicadas += 1
+    }
+    reg.Conta = t.conta
+    reg.Canal = t.canal
+    reg.Endereco = t.endereco
+    reg.Sessoes += 1
+    reg.UltimoContato = agora
+    reg.ConectadaDesde = t.conectada_desde
+    reg.sessoes = append(reg.sessoes, t)
+
+    return true, duplicada
+}
+
+// Invocado pelo tratador ao final da conexão de uma central identificada
+func (r *ReceptorIP) desregistra_central(t *TratadorReceptorIP) {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+    r.centrais_identificadas -= 1
+
+    reg := r.registro[t.macaddr]
+    reg.sessoes = slices.DeleteFunc(reg.sessoes, func(s *TratadorReceptorIP) bool { return s == t })
+    reg.UltimoContato = time.Now()
+    if len(reg.sessoes) > 0 {
+        ultima := reg.sessoes[len(reg.sessoes) - 1]
+        reg.Endereco = ultima.endereco
+        reg.ConectadaDesde = ultima.conectada_desde
+    } else {
+        reg.ConectadaDesde = time.Time{}
+    }
+}
+
+// Invocado pelo tratador de central identificada
+func (r *ReceptorIP) registra_heartbeat(t *TratadorReceptorIP) {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+    reg := r.registro[t.macaddr]
+    reg.UltimoHeartbeat = time.Now()
+    reg.UltimoContato = reg.UltimoHeartbeat
+}
+
+// Invocado pelo tratador de central identificada
+func (r *ReceptorIP) registra_evento(t *TratadorReceptorIP, descricao string) {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+    reg := r.registro[t.macaddr]
+    reg.UltimoEvento = time.Now()
+    reg.UltimoContato = reg.UltimoEvento
+    reg.DescricaoUltimoEvento = descricao
+}
+
+// Sessão mais recente da central, ou nil se não conectada
+func (r *ReceptorIP) sessao_central(macaddr string) *TratadorReceptorIP {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+    reg, ok := r.registro[macaddr]
+    if !ok || len(reg.sessoes) == 0 {
+        return nil
+    }
+    return reg.sessoes[len(reg.sessoes) - 1]
+}
+
+// Lista as centrais registradas (conectadas ou não), ordenadas por MAC
+func (r *ReceptorIP) Centrais() []RegistroCentral {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    lista := []RegistroCentral{}
+    for _, mac := range slices.Sorted(maps.Keys(r.registro)) {
+        reg := *r.registro[mac]
+        reg.SessoesAtivas = len(reg.sessoes)
+        reg.Conectada = reg.SessoesAtivas > 0
+        reg.sessoes = nil
+        lista = append(lista, reg)
+    }
+    return lista
+}
+
+// Obtém o registro de uma central pelo MAC
+func (r *ReceptorIP) Central(macaddr string) (RegistroCentral, bool) {
+    for _, reg := range r.Centrais() {
+        if reg.Mac == strings.ToLower(macaddr) {
+            return reg, true
+        }
+    }
+    return RegistroCentral{}, false
+}
@@ -0,0 +1,149 @@
+package goalarmeitbl
+
+import (
+    "testing"
+    "io"
+    "strings"
+    "time"
+)
+
+func TestRegistroCentrais(t *testing.T) {
+    r := receptorteste(t, "")
+    mac := []byte{0xaa, 0xbb, 0x0e}
+    nulo := func(int, []byte) (int, []byte) { return 0, nil }
+
+    c1 := centralfake_receptor(t, r, mac, nulo)
+    reg := aguardaregistro(t, r, "aa:bb:0e", func(reg RegistroCentral) bool { return reg.Conectada })
+    if reg.Conta != 1234 || reg.Canal != "Ethernet" || reg.Sessoes != 1 || reg.Duplicadas != 0 {
+        t.Errorf("Unexpected registry %+v", reg)
+    }
+
+    c1.Write([]byte{0xf7})
+    c1.Write(pacoteevento(130, 1, 5))
+    reg = aguardaregistro(t, r, "aa:bb:0e", func(reg RegistroCentral) bool {
+        return !reg.UltimoHeartbeat.IsZero() && !reg.UltimoEvento.IsZero()
+    })
+    if !strings.Contains(reg.DescricaoUltimoEvento, "5") {
+        t.Errorf("Unexpected last event %s", reg.DescricaoUltimoEvento)
+    }
+
+    c2 := centralfake_receptor(t, r, mac, nulo)
+    reg = aguardaregistro(t, r, "aa:bb:0e", func(reg RegistroCentral) bool { return reg.SessoesAtivas == 2 })
+    if reg.Duplicadas != 1 || reg.Sessoes != 2 {
+        t.Errorf("Duplicate not detected %+v", reg)
+    }
+
+    c1.Close()
+    aguardaregistro(t, r, "aa:bb:0e", func(reg RegistroCentral) bool { return reg.SessoesAtivas == 1 })
+    c2.Close()
+    reg = aguardaregistro(t, r, "aa:bb:0e", func(reg RegistroCentral) bool { return !reg.Conectada })
+    if reg.PrimeiroContato.After(reg.UltimoContato) || !reg.ConectadaDesde.IsZero() {
+        t.Errorf("Unexpected registry %+v", reg)
+    }
+
+    if _, ok := r.Central("aa:bb:ff"); ok {
+        t.Error("Unknown central should not be registered")
+    }
+}
+
+func TestMaxConn(t *testing.T) {
+    cfg := configteste(t, "maxconn = 2\n")
+    cfg.CarenciaIgnorada = 200 * time.Millisecond
+    r := receptorteste_cfg(t, cfg)
+    nulo := func(int, []byte) (int, []byte) { return 0, nil }
+
+    centralfake_receptor(t, r, []byte{0xaa, 0xbb, 0x21}, nulo)
+    centralfake_receptor(t, r, []byte{0xaa, 0xbb, 0x22}, nulo)
+    aguardaregistro(t, r, "aa:bb:21", func(reg RegistroCentral) bool { return reg.Conectada })
+    aguardaregistro(t, r, "aa:bb:22", func(reg RegistroCentral) bool { return reg.Conectada })
+
+    // central além do limite: ignorada, sem resposta, e fechada após a carência
+    c3 := conectarteste(t, r)
+    c3.Write(PacoteRIP{true, 0x94, []byte{0x94, 0x45, 0x12, 0x34, 0xaa, 0xbb, 0x23}}.Encode())
+    c3.SetReadDeadline(time.Now().Add(2 * time.Second))
+    resposta, err := io.ReadAll(c3)
+    if err != nil {
+        t.Fatalf("Extra connection not closed: %v", err)
+    }
+    if len(resposta) != 0 {
+        t.Errorf("Extra connection should have been ignored, got %s", HexPrint(resposta))
+    }
+    if _, ok := r.Central("aa:bb:23"); ok {
+        t.Error("Extra central should not be registered")
+    }
+    for _, mac := range []string{"aa:bb:21", "aa:bb:22"} {
+        if reg, _ := r.Central(mac); !reg.Conectada {
+            t.Errorf("Central %s should still be connected", mac)
+        }
+    }
+}
+
+func TestMaxConnIdentificacao(t *testing.T) {
+    cfg := configteste(t, "maxconn = 1\n")
+    // carência longa: a conexão recusada na identificação deve ser fechada de imediato
+    cfg.CarenciaIgnorada = 30 * time.Second
+    r := receptorteste_cfg(t, cfg)
+
+    // ambas as conexões aceitas antes de qualquer identificação, dentro do limite
+    c1 := conectarteste(t, r)
+    c2 := conectarteste(t, r)
+    time.Sleep(200 * time.Millisecond)
+
+    c1.Write(PacoteRIP{true, 0x94, []byte{0x94, 0x45, 0x12, 0x34, 0xaa, 0xbb, 0x24}}.Encode())
+    aguardaregistro(t, r, "aa:bb:24", func(reg RegistroCentral) bool { return reg.Conectada })
+
+    // segunda identificação excede o limite
+    c2.Write(PacoteRIP{true, 0x94, []byte{0x94, 0x45, 0x12, 0x34, 0xaa, 0xbb, 0x25}}.Encode())
+    c2.SetReadDeadline(time.Now().Add(5 * time.Second))
+    inicio := time.Now()
+    resposta, err := io.ReadAll(c2)
+    if err != nil {
+        t.Fatalf("Connection over the limit not closed: %v", err)
+    }
+    if len(resposta) != 0 {
+        t.Errorf("Connection over the limit should not be answered, got %s", HexPrint(resposta))
+    }
+    if time.Since(inicio) > 2 * time.Second {
+        t.Error("Connection over the limit should be closed at identification, not after the grace period")
+    }
+    if _, ok := r.Central("aa:bb:25"); ok {
+        t.Error("Central over the limit should not be registered")
+    }
+    if reg, _ := r.Central("aa:bb:24"); !reg.Conectada {
+        t.Error("First central should still be connected")
+    }
+}
+
+func TestCentralNaoAutorizada(t *testing.T) {
+    script, saida := ganchoteste(t)
+    cfg := configteste(t, "centrais = aa:bb:30\ngancho_msg = " + script + "\n")
+    cfg.CarenciaIgnorada = 200 * time.Millisecond
+    r := receptorteste_cfg(t, cfg)
+
+    centralfake_receptor(t, r, []byte{0xaa, 0xbb, 0x30}, func(int, []byte) (int, []byte) { return 0, nil })
+    aguardaregistro(t, r, "aa:bb:30", func(reg RegistroCentral) bool { return reg.Conectada })
+
+    // central não autorizada: informada ao gancho_msg, ignorada e fechada após a carência
+    c := conectarteste(t, r)
+    c.Write(PacoteRIP{true, 0x94, []byte{0x94, 0x45, 0x12, 0x34, 0xaa, 0xbb, 0x31}}.Encode())
+    c.SetReadDeadline(time.Now().Add(2 * time.Second))
+    inicio := time.Now()
+    resposta, err := io.ReadAll(c)
+    if err != nil {
+        t.Fatalf("Unauthorized connection not closed: %v", err)
+    }
+    if len(resposta) != 0 {
+        t.Errorf("Unauthorized connection should have been ignored, got %s", HexPrint(resposta))
+    }
+    if time.Since(inicio) < 100 * time.Millisecond {
+        t.Error("Unauthorized connection closed before the grace period")
+    }
+
+    linhas := aguardalinhas(t, saida, 1)
+    if len(linhas) != 1 || !strings.HasSuffix(linhas[0], "Central nao autorizada conta 1234 mac aa:bb:31") {
+        t.Errorf("Unexpected hook calls %v", linhas)
+    }
+    if _, ok := r.Central("aa:bb:31"); ok {
+        t.Error("Unauthorized central should not be registered")
+    }
+}
@@ -0,0 +1,189 @@
+package goalarmeitbl
+
+import (
+    "testing"
+    "io"
+    "net"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+func TestSupervisao(t *testing.T) {
+    script, saida := ganchoteste(t)
+    r := receptorteste(t, "centrais_esperadas = aa:bb:01, AA:BB:02\nprazo_supervisao = 0.3\n" +
+        "gancho_central = " + script + "\ngancho_ev = true\ngancho_msg = true\ngancho_watchdog = true\n")
+
+    c := centralfake_receptor(t, r, []byte{0xaa, 0xbb, 0x01}, func(int, []byte) (int, []byte) {
+        return 0, nil
+    })
+    // mantém a central 01 em contato
+    parar := make(chan bool)
+    defer close(parar)
+    go func() {
+        for {
+            select {
+            case <-parar:
+                return
+            case <-time.After(50 * time.Millisecond):
+                c.Write([]byte{0xf7})
+            }
+        }
+    }()
+
+    linhas := aguardalinhas(t, saida, 1)
+    if linhas[0] != "1 aa:bb:02" {
+        t.Errorf("Unexpected hook call %v", linhas)
+    }
+
+    c2 := centralfake_receptor(t, r, []byte{0xaa, 0xbb, 0x02}, func(int, []byte) (int, []byte) {
+        return 0, nil
+    })
+    linhas = aguardalinhas(t, saida, 2)
+    if linhas[1] != "0 aa:bb:02 1234" {
+        t.Errorf("Unexpected hook call %v", linhas)
+    }
+
+    c2.Close()
+    linhas = aguardalinhas(t, saida, 3)
+    if linhas[2] != "1 aa:bb:02 1234" || len(linhas) != 3 {
+        t.Errorf("Unexpected hook call %v", linhas)
+    }
+}
+
+func TestConfigSupervisao(t *testing.T) {
+    f := strings.NewReader("[receptorip]\ncentrais_esperadas = aa:bb\n" +
+        "gancho_central = x\ngancho_ev = x\ngancho_msg = x\ngancho_watchdog = x\n")
+    if _, err := NewReceptorIPConfig(f); err == nil {
+        t.Error("Should have failed")
+    }
+}
+
+func TestDedupEventos(t *testing.T) {
+    script, saida := ganchoteste(t)
+    r := receptorteste(t, "janela_dedup = 0.5\ngancho_ev = " + script + "\n")
+    nulo := func(int, []byte) (int, []byte) { return 0, nil }
+
+    c1 := centralfake_receptor(t, r, []byte{0xaa, 0xbb, 0x03}, nulo)
+    c2 := centralfake_receptor(t, r, []byte{0xaa, 0xbb, 0x03}, nulo)
+
+    c1.Write(pacoteevento(130, 1, 5))
+    aguardalinhas(t, saida, 1)
+    c2.Write(pacoteevento(130, 1, 5))
+    c1.Write(pacoteevento(130, 1, 5))
+    c2.Write(pacoteevento(130, 1, 6))
+    linhas := aguardalinhas(t, saida, 2)
+
+    time.Sleep(600 * time.Millisecond)
+    c1.Write(pacoteevento(130, 1, 5))
+    linhas = aguardalinhas(t, saida, 3)
+    if len(linhas) != 3 || linhas[0] != "130 1 5 1" || linhas[1] != "130 1 6 1" || linhas[2] != "130 1 5 1" {
+        t.Errorf("Unexpected hook calls %v", linhas)
+    }
+}
+
+func TestConfirmacaoGancho(t *testing.T) {
+    dir := t.TempDir()
+    saida := filepath.Join(dir, "saida.txt")
+    script := filepath.Join(dir, "gancho")
+    // falha na primeira invocação apenas
+    conteudo := "#!/bin/sh\necho \"$@\" >> " + saida + "\n[ -f " + dir + "/ok ] && exit 0\ntouch " + dir + "/ok\nexit 1\n"
+    if err := os.WriteFile(script, []byte(conteudo), 0755); err != nil {
+        t.Fatal(err)
+    }
+    r := receptorteste(t, "confirmacao = gancho\njanela_dedup = 60\nganchos_tentativas = 1\ngancho_ev = " + script + "\n")
+
+    c := conectarteste(t, r)
+    ack := func() bool {
+        c.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+        buf := make([]byte, 1)
+        n, _ := c.Read(buf)
+        return n == 1 && buf[0] == 0xfe
+    }
+
+    c.Write(PacoteRIP{true, 0x94, []byte{0x94, 0x45, 0x12, 0x34, 0xaa, 0xbb, 0x04}}.Encode())
+    if !ack() {
+        t.Fatal("Identification not acknowledged")
+    }
+
+    c.Write(pacoteevento(130, 1, 5))
+    if ack() {
+        t.Error("Event acknowledged despite hook failure")
+    }
+    // retransmissão pela central não deve ser tratada como duplicada
+    c.Write(pacoteevento(130, 1, 5))
+    if !ack() {
+        t.Error("Retransmitted event not acknowledged")
+    }
+    // duplicata de evento já confirmado é confirmada
+    c.Write(pacoteevento(130, 1, 5))
+    if !ack() {
+        t.Error("Duplicate event not acknowledged")
+    }
+
+    linhas := aguardalinhas(t, saida, 2)
+    if len(linhas) != 2 {
+        t.Errorf("Unexpected hook calls %v", linhas)
+    }
+}
+
+func TestConfigConfirmacao(t *testing.T) {
+    ganchos := "gancho_central = x\ngancho_ev = x\ngancho_msg = x\ngancho_watchdog = x\n"
+    for _, cfg := range []string{"confirmacao = journal\n", "confirmacao = talvez\n"} {
+        if _, err := NewReceptorIPConfig(strings.NewReader("[receptorip]\n" + cfg + ganchos)); err == nil {
+            t.Errorf("Should have failed: %s", cfg)
+        }
+    }
+    c, err := NewReceptorIPConfig(strings.NewReader("[receptorip]\njournal = j.jsonl\nconfirmacao = Journal\n" + ganchos))
+    if err != nil || c.Confirmacao != "journal" {
+        t.Errorf("Unexpected config %v %v", c.Confirmacao, err)
+    }
+}
+
+func TestReceptorClose(t *testing.T) {
+    journal := filepath.Join(t.TempDir(), "journal.jsonl")
+    r := receptorteste(t, "controle = 127.0.0.1:0\njournal = " + journal + "\n")
+    addrs := []string{r.Addr().String(), r.AddrControle().String()}
+
+    c := conectarteste(t, r)
+    c.Write(PacoteRIP{true, 0x94, []byte{0x94, 0x45, 0x12, 0x34, 0xaa, 0xbb, 0x0f}}.Encode())
+    aguardaregistro(t, r, "aa:bb:0f", func(reg RegistroCentral) bool { return reg.Conectada })
+
+    r.Close()
+    r.Wait()
+
+    // conexão da central fechada pelo receptor
+    c.SetReadDeadline(time.Now().Add(2 * time.Second))
+    if _, err := io.ReadAll(c); err != nil {
+        t.Errorf("Connection should have been closed: %v", err)
+    }
+    if reg, _ := r.Central("aa:bb:0f"); reg.Conectada {
+        t.Errorf("Unexpected registry %+v", reg)
+    }
+    // portas liberadas
+    for _, addr := range addrs {
+        l, err := net.Listen("tcp", addr)
+        if err != nil {
+            t.Fatal(err)
+        }
+        l.Close()
+    }
+}
+
+func TestReceptorCloseFotos(t *testing.T) {
+    r := receptorteste(t, "senha = 123456\ntamanho = 6\ncaddr = receptor\n")
+    // foto pendente: aguarda o prazo de gravação na central antes do download
+    r.fotos.Enfileirar("127.0.0.1", "aa:bb:0e", 1, 0)
+
+    r.Close()
+    r.Wait()
+
+    if r.fotos.task != nil {
+        t.Error("Pending photo timeout should have been freed")
+    }
+    // tratador encerrado não bloqueia quem ainda tente enfileirar
+    for range 20 {
+        r.fotos.Enfileirar("127.0.0.1", "aa:bb:0e", 1, 0)
+    }
+}
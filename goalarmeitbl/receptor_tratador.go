@@ -3,8 +3,10 @@ package goalarmeitbl
 import (
     "fmt"
     "log"
+    "net"
     "time"
     "slices"
+    "sync"
     "github.com/ncruces/go-strftime"
 )
 
@@ -13,23 +15,48 @@ type TratadorReceptorIP struct {
     tcp *TCPSession
     buffer []byte
     central_identificada bool
+    ignorar bool
     to_ident *Timeout
     to_comm *Timeout
     to_incompleta *Timeout
+    to_ignorar *Timeout
+    // imutáveis após a identificação, lidos também pelo registro de centrais
+    macaddr string
+    conta int
+    canal string
+    endereco string
+    conectada_desde time.Time
+
+    // acessado também pelo túnel ISECNet2
+    mutex sync.Mutex
+    fechado bool
+    tunel *TunelIsecNet2
 }
 
 func NewTratadorReceptorIP(receptor *ReceptorIP, tcp *TCPSession) *TratadorReceptorIP {
     t := new(TratadorReceptorIP)
     t.receptor = receptor
     t.tcp = tcp
+    t.endereco = tcp.RemoteAddr().String()
+    t.conectada_desde = time.Now()
     fmt.Println("TratadorReceptorIP: inicio")
     t.to_ident = t.tcp.Timeout(120 * time.Second, 0, "to_ident")
     t.to_comm = t.tcp.Timeout(600 * time.Second, 0, "to_comm")
 
-    go func() {
+    if !receptor.valida_maxconn() {
+        fmt.Println("TratadorReceptorIP: número máximo de conexões atingido - conexão ignorada")
+        t.ignorar_central()
+    }
+
+    receptor.adiciona_tratador(t)
+    receptor.wg_tratadores.Go(func() {
         for evt := range t.tcp.Events {
             switch evt.Name {
             case "Recv":
+                if t.ignorar {
+                    // central recusada, não respondemos mais nada
+                    break
+                }
                 buf, _ := evt.Cargo.([]byte)
                 t.buffer = slices.Concat(t.buffer, buf)
                 t.parse()
@@ -37,34 +64,64 @@ func NewTratadorReceptorIP(receptor *ReceptorIP, tcp *TCPSession) *TratadorRecep
                 // pass
             case "to_ident":
                 fmt.Println("TratadorReceptorIP: timeout de identificação")
-                t.tcp.Close()
+                t.fechar()
             case "to_comm":
                 fmt.Println("TratadorReceptorIP: timeout de comunicação")
-                t.tcp.Close()
+                t.fechar()
             case "to_incompleta":
                 fmt.Println("TratadorReceptorIP: timeout de mensagem incompleta")
-                t.tcp.Close()
+                t.fechar()
+            case "to_ignorar":
+                fmt.Println("TratadorReceptorIP: fim da carência de conexão ignorada")
+                t.fechar()
             case "SendEof", "RecvEof", "Err":
                 fmt.Println("TratadorReceptorIP: Conexão terminada ", evt.Name)
-                t.tcp.Close()
+                t.fechar()
             }
         }
+        if t.central_identificada {
+            t.receptor.desregistra_central(t)
+        }
+        t.receptor.remove_tratador(t)
         fmt.Println("TratadorReceptorIP: fim ----")
-    }()
+    })
 
     return t
 }
 
 // Todos os métodos abaixo são invocados apenas pela goroutine e são privados
 
-func (t *TratadorReceptorIP) msg_para_gancho(msg string) {
-    msg = strftime.Format("%Y-%m-%dT%H:%M:%S", time.Now()) + " " + msg
-    t.receptor.InvocaGancho("msg", msg)
+// Identidade da central para os ganchos, se já identificada
+func (t *TratadorReceptorIP) dados_gancho() DadosGancho {
+    dados := DadosGancho{Endereco: t.endereco}
+    if t.central_identificada {
+        dados.Mac = t.macaddr
+        dados.Conta = t.conta
+        dados.Canal = t.canal
+    }
+    return dados
 }
 
-func (t *TratadorReceptorIP) ev_para_gancho(codigo int, particao int, zona int, qualificador int) {
-    msg := fmt.Sprintf("%d %d %d %d", codigo, particao, zona, qualificador)
-    t.receptor.InvocaGancho("ev", msg)
+// evento é nil se a mensagem não se refere a um evento de alarme
+func (t *TratadorReceptorIP) msg_para_gancho(msg string, evento *RIPAlarme) {
+    dados := t.dados_gancho()
+    dados.Quando = time.Now()
+    dados.Mensagem = msg
+    dados.Evento = evento
+    msg = strftime.Format("%Y-%m-%dT%H:%M:%S", dados.Quando) + " " + msg
+    t.receptor.invoca_gancho_central(t.macaddr, "msg", dados, msg)
+}
+
+// Aguarda a execução do gancho apenas se a confirmação do evento depende dele
+func (t *TratadorReceptorIP) ev_para_gancho(evento RIPAlarme) error {
+    dados := t.dados_gancho()
+    dados.Evento = &evento
+    msg := fmt.Sprintf("%d %d %d %d", evento.Codigo, evento.Particao, evento.Zona, evento.Qualificador)
+    resultado, err := t.receptor.invoca_gancho_central(t.macaddr, "ev", dados, msg)
+    if err != nil || t.receptor.cfg.Confirmacao != "gancho" {
+        return err
+    }
+    return <-resultado
 }
 
 func (t *TratadorReceptorIP) enviar(pacote PacoteRIP) {
@@ -77,8 +134,18 @@ func (t *TratadorReceptorIP) parse() {
     log.Print("TratadorReceptorIP: Recebido até agora ", HexPrint(t.buffer))
     t.to_comm.Restart()
 
-    for {
-        pacote, consumo := ExtrairFrameRIP(t.buffer)
+    for !t.ignorar {
+        // Respostas ISECNet2 multiplexadas são procuradas apenas com túnel aberto,
+        // para não interferir de forma alguma com o protocolo RIP no caso comum
+        tunel := t.tunel_ativo()
+        multiplexado, consumo := false, 0
+        if tunel != nil {
+            multiplexado, consumo = PacoteIsecNet2Multiplexado(t.buffer)
+        }
+        var pacote PacoteRIP
+        if !multiplexado {
+            pacote, consumo = ExtrairFrameRIP(t.buffer)
+        }
         if consumo <= 0 {
             break
         }
@@ -86,8 +153,15 @@ func (t *TratadorReceptorIP) parse() {
             t.to_incompleta.Free()
             t.to_incompleta = nil
         }
+        if multiplexado {
+            log.Print("TratadorReceptorIP: pacote ISECNet2 multiplexado")
+            tunel.entregar(Event{"Recv", slices.Clone(t.buffer[:consumo])})
+            t.buffer = t.buffer[consumo:]
+            continue
+        }
+        bruto := t.buffer[:consumo]
         t.buffer = t.buffer[consumo:]
-        t.trata_pacote(pacote)
+        t.trata_pacote(pacote, bruto)
     }
 
     if len(t.buffer) > 0 && t.to_incompleta == nil {
@@ -95,7 +169,10 @@ func (t *TratadorReceptorIP) parse() {
     }
 }
 
-func (t *TratadorReceptorIP) trata_pacote(pacote PacoteRIP) {
+func (t *TratadorReceptorIP) trata_pacote(pacote PacoteRIP, bruto []byte) {
+    entrada := NovaEntradaJournal(t.endereco, pacote, bruto)
+    gancho_ok := true
+
     if pacote.Longo {
         switch pacote.Tipo {
         case 0x00:
@@ -107,9 +184,9 @@ func (t *TratadorReceptorIP) trata_pacote(pacote PacoteRIP) {
         case 0x94:
             t.identificacao_central(pacote)
         case 0xb0:
-            t.evento_alarme(pacote, false)
+            entrada.Evento, entrada.Duplicado, gancho_ok = t.evento_alarme(pacote, false)
         case 0xb5:
-            t.evento_alarme(pacote, true)
+            entrada.Evento, entrada.Duplicado, gancho_ok = t.evento_alarme(pacote, true)
         default:       
             fmt.Printf("TratadorReceptorIP: solicitação desconhecida %02x payload = %s\n", pacote.Tipo, HexPrint(pacote.Payload))
             t.resposta_generica()
@@ -118,35 +195,209 @@ func (t *TratadorReceptorIP) trata_pacote(pacote PacoteRIP) {
         // pacote curto
         if pacote.Tipo == 0xf7 {
             log.Print("TratadorReceptorIP: heartbeat da central")
+            if t.central_identificada {
+                t.receptor.registra_heartbeat(t)
+            }
             t.resposta_generica()
         }
     }
+
+    journal_ok := t.registra_journal(entrada) == nil
+
+    if entrada.Evento != nil {
+        t.confirma_evento(*entrada.Evento, entrada.Duplicado, gancho_ok, journal_ok)
+    }
+}
+
+// Envia o ACK de evento de alarme. No modo "at-least-once" (confirmacao = journal ou gancho),
+// o ACK é retido se o evento não foi gravado no journal ou se o gancho falhou, para que a central
+// retransmita o evento
+func (t *TratadorReceptorIP) confirma_evento(evento RIPAlarme, duplicado bool, gancho_ok bool, journal_ok bool) {
+    confirmar := true
+    if evento.Valido && !duplicado {
+        switch t.receptor.cfg.Confirmacao {
+        case "journal":
+            confirmar = journal_ok
+        case "gancho":
+            confirmar = gancho_ok
+        }
+    }
+
+    if !confirmar {
+        fmt.Printf("TratadorReceptorIP: evento não confirmado à central, aguardando retransmissão codigo %d " +
+            "particao %d zona %d\n", evento.Codigo, evento.Particao, evento.Zona)
+        t.receptor.esquecer_evento(t.macaddr, evento)
+        return
+    }
+    t.resposta_generica()
+}
+
+// Grava frame no journal, já com a identificação da central se disponível
+func (t *TratadorReceptorIP) registra_journal(entrada EntradaJournal) error {
+    if t.receptor.journal == nil {
+        return nil
+    }
+    if t.central_identificada {
+        entrada.Mac = t.macaddr
+        entrada.Conta = t.conta
+    }
+    err := t.receptor.journal.Registrar(entrada)
+    if err != nil {
+        fmt.Println("TratadorReceptorIP: falha ao gravar journal:", err)
+    }
+    return err
 }
 
 func (t *TratadorReceptorIP) resposta_generica() {
     t.enviar(RIPRespostaGenerica())
 }
 
-func (t *TratadorReceptorIP) identificacao_central(pacote PacoteRIP) {
-    defer t.resposta_generica()
+// Fecha a conexão com a central, notificando o túnel ISECNet2 se houver
+func (t *TratadorReceptorIP) fechar() {
+    t.mutex.Lock()
+    if t.fechado {
+        t.mutex.Unlock()
+        return
+    }
+    t.fechado = true
+    tunel := t.tunel
+    t.tunel = nil
+    t.mutex.Unlock()
 
-    conta, macaddr, ok, msg := ParseRIPIdentificacaoCentral(pacote)
+    if tunel != nil {
+        tunel.entregar(Event{"RecvEof", nil})
+    }
+    t.tcp.Close()
+}
+
+// Métodos de suporte ao túnel ISECNet2, invocados por outras goroutines
+
+func (t *TratadorReceptorIP) abrir_tunel(tunel *TunelIsecNet2) error {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+    if t.fechado {
+        return ErrCentralDesconectada
+    }
+    if t.tunel != nil {
+        return fmt.Errorf("%w: túnel em uso por outro comando", ErrCentralOcupada)
+    }
+    t.tunel = tunel
+    return nil
+}
+
+func (t *TratadorReceptorIP) fechar_tunel(tunel *TunelIsecNet2) {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+    if t.tunel == tunel {
+        t.tunel = nil
+    }
+}
+
+func (t *TratadorReceptorIP) tunel_ativo() *TunelIsecNet2 {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+    return t.tunel
+}
+
+// Retorna false se a conexão com a central já foi fechada
+func (t *TratadorReceptorIP) enviar_tunel(pacote []byte) bool {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+    if t.fechado {
+        return false
+    }
+    log.Print("TratadorReceptorIP: Enviando ISECNet2 multiplexado ", HexPrint(pacote))
+    t.tcp.Send(pacote)
+    return true
+}
+
+// Deixa de responder à central. A conexão é fechada após um período de carência,
+// para que a central não reconecte imediatamente em loop.
+func (t *TratadorReceptorIP) ignorar_central() {
+    t.ignorar = true
+    t.buffer = nil
+    if t.to_incompleta != nil {
+        t.to_incompleta.Free()
+        t.to_incompleta = nil
+    }
+    if t.to_ident != nil {
+        t.to_ident.Free()
+        t.to_ident = nil
+    }
+    t.to_comm.Stop()
+    t.to_ignorar = t.tcp.Timeout(t.receptor.cfg.CarenciaIgnorada, 0, "to_ignorar")
+}
+
+func (t *TratadorReceptorIP) identificacao_central(pacote PacoteRIP) {
+    conta, macaddr, canal, ok, msg := ParseRIPIdentificacaoCentral(pacote)
 
     if !ok {
         fmt.Println(msg)
+        t.resposta_generica()
+        return
+    }
+
+    fmt.Printf("TratadorReceptorIP: identificacao central conta %d mac %s canal %s\n", conta, macaddr, canal)
+
+    if t.central_identificada {
+        // já registrada nesta sessão
+        t.resposta_generica()
         return
     }
 
-    fmt.Printf("TratadorReceptorIP: identificacao central conta %d mac %s\n", conta, macaddr)
+    if !t.receptor.cfg.Centrais.MatchString(macaddr) {
+        msg := fmt.Sprintf("Central nao autorizada conta %d mac %s", conta, macaddr)
+        fmt.Println("TratadorReceptorIP:", msg)
+        t.msg_para_gancho(msg, nil)
+        t.ignorar_central()
+        return
+    }
 
-    // TODO? testar se central é autorizada a conectar, como na versão Python
-    // TODO? testar número máximo conexões
+    // Testa novamente maxconn pois há uma "janela" de tempo entre conexão e
+    // identificação onde mais conexões podem ter sido aceitas. Centrais com
+    // firmware antigo abrem conexões duplicadas, que seriam fonte de eventos
+    // duplicados.
+    t.macaddr = macaddr
+    t.conta = conta
+    t.canal = canal
+    registrada, duplicada := t.receptor.registra_central(t)
+    if !registrada {
+        fmt.Println("TratadorReceptorIP: número máximo de conexões atingido - conexão fechada")
+        // interrompe o processamento do restante do buffer
+        t.ignorar = true
+        t.buffer = nil
+        t.fechar()
+        return
+    }
 
     t.central_identificada = true
     if t.to_ident != nil {
         t.to_ident.Free()
         t.to_ident = nil
     }
+
+    if duplicada {
+        msg := fmt.Sprintf("Conexao duplicada da central conta %d mac %s", conta, macaddr)
+        fmt.Println("TratadorReceptorIP:", msg)
+        t.msg_para_gancho(msg, nil)
+    }
+
+    t.resposta_generica()
+}
+
+func (t *TratadorReceptorIP) enfileirar_fotos(evento RIPAlarme) {
+    if t.receptor.fotos == nil {
+        log.Print("TratadorReceptorIP: download de fotos desabilitado")
+        return
+    }
+    ip_addr, _, err := net.SplitHostPort(t.tcp.RemoteAddr().String())
+    if err != nil {
+        fmt.Println("TratadorReceptorIP: endereço da central indeterminado:", err)
+        return
+    }
+    for nrfoto := range evento.NrFotos {
+        t.receptor.fotos.Enfileirar(ip_addr, t.macaddr, evento.IndiceFotos, nrfoto)
+    }
 }
 
 func (t *TratadorReceptorIP) solicita_data_hora(pacote PacoteRIP) {
@@ -154,26 +405,43 @@ func (t *TratadorReceptorIP) solicita_data_hora(pacote PacoteRIP) {
     t.enviar(RIPRespostaDataHora(time.Now()))
 }
 
-func (t *TratadorReceptorIP) evento_alarme(pacote PacoteRIP, com_foto bool) {
-    defer t.resposta_generica()
-
+// Retorna o evento decodificado, se foi ignorado por ser duplicado, e se gancho_ev teve sucesso
+// O ACK é enviado posteriormente por confirma_evento()
+func (t *TratadorReceptorIP) evento_alarme(pacote PacoteRIP, com_foto bool) (*RIPAlarme, bool, bool) {
     evento := ParseRIPAlarme(pacote, com_foto)
     if !evento.Valido {
         fmt.Println(evento.Erro)
-        return
+        return &evento, false, true
+    }
+
+    if t.receptor.evento_duplicado(t.macaddr, evento) {
+        fmt.Printf("TratadorReceptorIP: evento duplicado ignorado contact_id %d codigo %d qualificador %d " +
+            "particao %d zona %d\n", evento.ContactId, evento.Codigo, evento.Qualificador, evento.Particao, evento.Zona)
+        return &evento, true, true
     }
 
-    t.ev_para_gancho(evento.Codigo, evento.Particao, evento.Zona, evento.Qualificador)
+    gancho_ok := t.ev_para_gancho(evento) == nil
 
     if evento.CodigoConhecido {
         fmt.Println(evento.DescricaoHumana)
-        t.msg_para_gancho(evento.DescricaoHumana)
-        // TODO? download fotos
+        t.msg_para_gancho(evento.DescricaoHumana, &evento)
+        t.registra_evento(evento.DescricaoHumana)
+        if com_foto {
+            t.enfileirar_fotos(evento)
+        }
     } else {
         msg := fmt.Sprintf("Evento de alarme canal %02x contact_id %d tipo %d qualificador %d " +
               "codigo %d particao %d zona %d", evento.Canal, evento.ContactId, evento.Tipo, evento.Qualificador,
               evento.Codigo, evento.Particao, evento.Zona)
         fmt.Println(msg)
-        t.msg_para_gancho(msg)
+        t.msg_para_gancho(msg, &evento)
+        t.registra_evento(msg)
+    }
+    return &evento, false, gancho_ok
+}
+
+func (t *TratadorReceptorIP) registra_evento(descricao string) {
+    if t.central_identificada {
+        t.receptor.registra_evento(t, descricao)
     }
 }
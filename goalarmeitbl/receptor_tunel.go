@@ -0,0 +1,130 @@
+package goalarmeitbl
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "sync"
+    "time"
+)
+
+// Túnel ISECNet2 através da conexão de uma central com o Receptor IP.
+// Permite executar comandos (ComandoCentral) sem abrir nova conexão TCP
+// com a central, que pode estar inacessível (e.g. atrás de CGNAT).
+//
+// Apenas um túnel por central pode estar aberto de cada vez, pois não há
+// como rotear respostas para mais de um comando simultâneo.
+//
+// Implementa TransporteComando, emitindo os mesmos eventos que TCPClient.
+
+type TunelIsecNet2 struct {
+    Events chan Event
+    tratador *TratadorReceptorIP
+    timeouts *Parent
+
+    mutex sync.Mutex
+    fechado bool
+}
+
+// Erro de túnel indisponível
+var ErrCentralDesconectada = fmt.Errorf("%w: central não conectada ao receptor", ErrConexao)
+
+// Abre túnel para a central identificada pelo MAC (formato "aa:bb:cc")
+// O túnel emite Connected ou NotConnected (com o erro como carga) como primeiro evento
+func (r *ReceptorIP) NewTunelIsecNet2(macaddr string) *TunelIsecNet2 {
+    tunel := new(TunelIsecNet2)
+    tunel.Events = make(chan Event, 8)
+    tunel.timeouts = NewParent("TunelIsecNet2", "Timeout", nil)
+
+    tratador := r.sessao_central(macaddr)
+
+    if tratador == nil {
+        log.Printf("TunelIsecNet2 %p: central %s não conectada", tunel, macaddr)
+        tunel.fechado = true
+        tunel.Events <- Event{"NotConnected", ErrCentralDesconectada}
+        close(tunel.Events)
+        return tunel
+    }
+
+    if err := tratador.abrir_tunel(tunel); err != nil {
+        log.Printf("TunelIsecNet2 %p: %v", tunel, err)
+        tunel.fechado = true
+        tunel.Events <- Event{"NotConnected", err}
+        close(tunel.Events)
+        return tunel
+    }
+
+    log.Printf("TunelIsecNet2 %p: aberto para central %s", tunel, macaddr)
+    tunel.tratador = tratador
+    tunel.Events <- Event{"Connected", nil}
+    return tunel
+}
+
+// Conector de ComandoCentral que utiliza o túnel
+func (r *ReceptorIP) ConectorTunel(macaddr string) ConectorComando {
+    return func(_ context.Context) (TransporteComando, chan Event) {
+        tunel := r.NewTunelIsecNet2(macaddr)
+        return tunel, tunel.Events
+    }
+}
+
+// Executa uma sequência de comandos numa central conectada ao receptor, através da conexão existente
+func (r *ReceptorIP) Executar(ctx context.Context, macaddr string, cred Credenciais, politica PoliticaRetry,
+        subs ...ComandoCentralSub) (RelatorioComando, error) {
+    comando := NewSequenciaComandosTransporte(ctx, subs, r.ConectorTunel(macaddr), cred.Senha, cred.TamSenha, politica)
+    comando.Resultado() // bloqueia
+    return comando.Relatorio(), comando.Erro()
+}
+
+// Envia pacote ISECNet2 à central
+// nil = fim da comunicação (equivalente a fechar a conexão no sentido tx)
+func (tunel *TunelIsecNet2) Send(pacote []byte) {
+    if len(pacote) == 0 {
+        // a central não fecha a conexão com o receptor após a despedida
+        tunel.entregar(Event{"SendEof", nil})
+        return
+    }
+    if !tunel.tratador.enviar_tunel(pacote) {
+        tunel.entregar(Event{"Err", nil})
+    }
+}
+
+// Fecha o túnel. Nenhum evento é emitido após o retorno
+func (tunel *TunelIsecNet2) Close() {
+    tunel.timeouts.DisownAll()
+    if tunel.tratador != nil {
+        // fora do mutex do túnel, para não inverter a ordem de travamento com o tratador
+        tunel.tratador.fechar_tunel(tunel)
+    }
+
+    tunel.mutex.Lock()
+    defer tunel.mutex.Unlock()
+
+    if tunel.fechado {
+        return
+    }
+    tunel.fechado = true
+    close(tunel.Events)
+    log.Printf("TunelIsecNet2 %p: fechado", tunel)
+}
+
+// Cria novo Timeout associado ao túnel
+func (tunel *TunelIsecNet2) Timeout(avgto time.Duration, fudge time.Duration, cbchmsg string) *Timeout {
+    return NewTimeout(avgto, fudge, tunel.Events, cbchmsg, tunel.timeouts)
+}
+
+// Encaminha evento ao usuário do túnel. Invocado pelo tratador.
+// Nunca bloqueia, pois o tratador não pode ficar à mercê do usuário do túnel
+func (tunel *TunelIsecNet2) entregar(evt Event) {
+    tunel.mutex.Lock()
+    defer tunel.mutex.Unlock()
+
+    if tunel.fechado {
+        return
+    }
+    select {
+    case tunel.Events <- evt:
+    default:
+        log.Printf("TunelIsecNet2 %p: fila cheia, evento %s descartado", tunel, evt.Name)
+    }
+}
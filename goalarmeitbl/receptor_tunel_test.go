@@ -0,0 +1,64 @@
+package goalarmeitbl
+
+import (
+    "testing"
+    "context"
+    "errors"
+    "time"
+)
+
+func TestTunelIsecNet2(t *testing.T) {
+    r := receptorteste(t, "gancho_central = true\ngancho_ev = true\ngancho_msg = true\ngancho_watchdog = true\n")
+
+    cred := Credenciais{123456, 6}
+    sub, _ := NewSolicitarStatus(nil)
+    _, err := r.Executar(context.Background(), "aa:bb:0c", cred, SemRetry, sub)
+    if !errors.Is(err, ErrCentralDesconectada) || !errors.Is(err, ErrConexao) {
+        t.Errorf("Expected disconnected error, got %v", err)
+    }
+
+    centralfake_receptor(t, r, []byte{0xaa, 0xbb, 0x0c}, func(cmd int, payload []byte) (int, []byte) {
+        if cmd == 0x0b4a {
+            return 0x0b4a, hexpayload(statusPayloadReadme)
+        }
+        return 0, nil
+    })
+
+    // aguarda identificação
+    var relatorio RelatorioComando
+    politica := PoliticaRetry{20, 50 * time.Millisecond, 50 * time.Millisecond}
+    relatorio, err = r.Executar(context.Background(), "aa:bb:0c", cred, politica, sub)
+    if err != nil {
+        t.Fatal("Command failed ", err)
+    }
+    status, ok := relatorio.Dados.(StatusCentral)
+    if !ok || status.Firmware != [3]int{2, 3, 1} {
+        t.Errorf("Unexpected report %v", relatorio)
+    }
+
+    // túnel pode ser reaberto na mesma conexão
+    sub2, _ := NewComandoNulo(nil)
+    if _, err = r.Executar(context.Background(), "aa:bb:0c", cred, SemRetry, sub2); err != nil {
+        t.Error("Second command failed ", err)
+    }
+}
+
+func TestPacoteIsecNet2Multiplexado(t *testing.T) {
+    pacote := PacoteIsecNet2(0x0b4a, nil)
+    ok, n := PacoteIsecNet2Multiplexado(pacote)
+    if !ok || n != len(pacote) {
+        t.Errorf("failed I %v %d", ok, n)
+    }
+    ok, n = PacoteIsecNet2Multiplexado(pacote[:5])
+    if !ok || n != 0 {
+        t.Errorf("failed II %v %d", ok, n)
+    }
+    ok, _ = PacoteIsecNet2Multiplexado([]byte{0xf7})
+    if ok {
+        t.Errorf("failed III")
+    }
+    ok, _ = PacoteIsecNet2Multiplexado(RIPRespostaDataHora(time.Now()).Encode())
+    if ok {
+        t.Errorf("failed IV")
+    }
+}
@@ -0,0 +1,200 @@
+package goalarmeitbl
+
+import (
+    "bytes"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "slices"
+    "sync"
+    "text/template"
+    "time"
+)
+
+// Webhook: consumidor de eventos que os envia a um endpoint HTTP, dispensando scripts
+// que apenas invocam curl. Faz suas próprias novas tentativas (o despachante não repete
+// consumidores); esgotadas estas, o evento é gravado no arquivo de falhas (dead letter), se configurado
+
+type ConfigWebhook struct {
+    Nome string
+    Tipos []string                // tipos de gancho atendidos (ev, msg, ...), vazio = todos
+    URL string
+    Metodo string
+    Cabecalhos map[string]string
+    Corpo string                  // template (text/template) sobre DadosGancho, vazio = DadosGancho em JSON
+    Timeout time.Duration         // por tentativa
+    Tentativas int
+    Intervalo time.Duration       // antes da segunda tentativa, dobrando a cada tentativa
+    Segredo string                // se informado, assinatura HMAC-SHA256 do corpo
+    ArquivoFalhas string          // eventos não entregues, "" = descartados
+}
+
+// Cabeçalho com a assinatura do corpo, no formato "sha256=<hex>"
+const CabecalhoAssinatura = "X-Alarme-Assinatura"
+
+// Evento não entregue, mas gravado no arquivo de falhas. Ainda assim é uma falha do gancho:
+// com confirmacao = gancho, o evento não é confirmado à central
+var ErrEventoArquivado = errors.New("evento gravado no arquivo de falhas")
+
+type Webhook struct {
+    cfg ConfigWebhook
+    corpo *template.Template
+    cliente *http.Client
+    politica PoliticaRetry
+    mutex sync.Mutex // arquivo de falhas
+}
+
+// Erro de entrega que não adianta repetir (e.g. HTTP 400)
+type erroWebhookPermanente struct {
+    err error
+}
+
+func (e erroWebhookPermanente) Error() string {
+    return e.err.Error()
+}
+
+func (e erroWebhookPermanente) Unwrap() error {
+    return e.err
+}
+
+// Compila o template do corpo. A função "json" codifica um valor em JSON, e.g. {"texto": {{json .Mensagem}}}
+func compilar_corpo_webhook(nome string, corpo string) (*template.Template, error) {
+    if corpo == "" {
+        return nil, nil
+    }
+    return template.New(nome).Funcs(template.FuncMap{
+        "json": func(v any) (string, error) {
+            b, err := json.Marshal(v)
+            return string(b), err
+        },
+    }).Parse(corpo)
+}
+
+func NewWebhook(cfg ConfigWebhook) (*Webhook, error) {
+    w := new(Webhook)
+    w.cfg = cfg
+    var err error
+    w.corpo, err = compilar_corpo_webhook(cfg.Nome, cfg.Corpo)
+    if err != nil {
+        return nil, err
+    }
+    w.cliente = &http.Client{Timeout: cfg.Timeout}
+    w.politica = PoliticaRetry{max(cfg.Tentativas, 1), cfg.Intervalo, 30 * time.Second}
+    return w, nil
+}
+
+// Implementa ConsumidorEventos. Retorna erro se o evento não foi entregue, envolvendo
+// ErrEventoArquivado se foi gravado no arquivo de falhas
+func (w *Webhook) Consumir(dados DadosGancho) error {
+    if len(w.cfg.Tipos) > 0 && !slices.Contains(w.cfg.Tipos, dados.Tipo) {
+        return nil
+    }
+
+    corpo, err := w.montar_corpo(dados)
+    if err != nil {
+        return w.registrar_falha(dados, nil, erroWebhookPermanente{err})
+    }
+
+    for tentativa := 1; ; tentativa++ {
+        err = w.enviar(corpo)
+        if err == nil {
+            return nil
+        }
+        fmt.Printf("Webhook %s: falha ao enviar %s: %v (tentativa %d de %d)\n", w.cfg.Nome, dados.Tipo, err,
+            tentativa, w.politica.Tentativas)
+        var permanente erroWebhookPermanente
+        if tentativa >= w.politica.Tentativas || errors.As(err, &permanente) {
+            break
+        }
+        time.Sleep(w.politica.intervalo(tentativa))
+    }
+    return w.registrar_falha(dados, corpo, err)
+}
+
+func (w *Webhook) montar_corpo(dados DadosGancho) ([]byte, error) {
+    if w.corpo == nil {
+        return json.Marshal(dados)
+    }
+    var corpo bytes.Buffer
+    if err := w.corpo.Execute(&corpo, dados); err != nil {
+        return nil, err
+    }
+    return corpo.Bytes(), nil
+}
+
+func (w *Webhook) enviar(corpo []byte) error {
+    req, err := http.NewRequest(w.cfg.Metodo, w.cfg.URL, bytes.NewReader(corpo))
+    if err != nil {
+        return erroWebhookPermanente{err}
+    }
+    req.Header.Set("Content-Type", "application/json")
+    for nome, valor := range w.cfg.Cabecalhos {
+        req.Header.Set(nome, valor)
+    }
+    if w.cfg.Segredo != "" {
+        mac := hmac.New(sha256.New, []byte(w.cfg.Segredo))
+        mac.Write(corpo)
+        req.Header.Set(CabecalhoAssinatura, "sha256=" + hex.EncodeToString(mac.Sum(nil)))
+    }
+
+    resp, err := w.cliente.Do(req)
+    if err != nil {
+        return err
+    }
+    io.Copy(io.Discard, resp.Body)
+    resp.Body.Close()
+
+    if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+        return nil
+    }
+    err = fmt.Errorf("HTTP status %d", resp.StatusCode)
+    if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout &&
+            resp.StatusCode != http.StatusTooManyRequests {
+        return erroWebhookPermanente{err}
+    }
+    return err
+}
+
+// Entrada do arquivo de falhas, uma por linha, em JSON
+type FalhaWebhook struct {
+    Quando time.Time `json:"quando"`
+    Webhook string `json:"webhook"`
+    URL string `json:"url"`
+    Erro string `json:"erro"`
+    Corpo string `json:"corpo,omitempty"`
+    Dados DadosGancho `json:"dados"`
+}
+
+func (w *Webhook) registrar_falha(dados DadosGancho, corpo []byte, erro error) error {
+    if w.cfg.ArquivoFalhas == "" {
+        return erro
+    }
+    linha, err := json.Marshal(FalhaWebhook{Quando: time.Now(), Webhook: w.cfg.Nome, URL: w.cfg.URL,
+        Erro: erro.Error(), Corpo: string(corpo), Dados: dados})
+    if err != nil {
+        return errors.Join(erro, err)
+    }
+
+    w.mutex.Lock()
+    defer w.mutex.Unlock()
+
+    f, err := os.OpenFile(w.cfg.ArquivoFalhas, os.O_WRONLY | os.O_APPEND | os.O_CREATE, 0644)
+    if err != nil {
+        return errors.Join(erro, err)
+    }
+    defer f.Close()
+    if _, err := f.Write(append(linha, '\n')); err != nil {
+        return errors.Join(erro, err)
+    }
+    if err := f.Sync(); err != nil {
+        return errors.Join(erro, err)
+    }
+    fmt.Printf("Webhook %s: evento %s gravado em %s\n", w.cfg.Nome, dados.Tipo, w.cfg.ArquivoFalhas)
+    return fmt.Errorf("%w: %w", ErrEventoArquivado, erro)
+}
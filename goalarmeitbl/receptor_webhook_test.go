@@ -0,0 +1,158 @@
+package goalarmeitbl
+
+import (
+    "testing"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "path/filepath"
+    "slices"
+    "strings"
+    "sync/atomic"
+    "time"
+)
+
+func TestWebhook(t *testing.T) {
+    recebidos := make(chan *http.Request, 10)
+    corpos := make(chan []byte, 10)
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+        corpo, _ := io.ReadAll(req.Body)
+        recebidos <- req
+        corpos <- corpo
+    }))
+    defer srv.Close()
+
+    w, err := NewWebhook(ConfigWebhook{Nome: "teste", Tipos: []string{"msg"}, URL: srv.URL + "/alarme", Metodo: "PUT",
+        Cabecalhos: map[string]string{"Authorization": "Bearer abc"},
+        Corpo: `{"texto": {{json .Mensagem}}, "conta": {{.Conta}}}`,
+        Timeout: time.Second, Tentativas: 3, Intervalo: 10 * time.Millisecond, Segredo: "segredo"})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // tipo não atendido
+    if err := w.Consumir(DadosGancho{Tipo: "ev"}); err != nil || len(recebidos) != 0 {
+        t.Error("Event type should have been filtered")
+    }
+
+    if err := w.Consumir(DadosGancho{Tipo: "msg", Conta: 1234, Mensagem: "Disparo \"zona\" 5"}); err != nil {
+        t.Fatal(err)
+    }
+    req, corpo := <-recebidos, <-corpos
+    if req.Method != "PUT" || req.URL.Path != "/alarme" || req.Header.Get("Authorization") != "Bearer abc" {
+        t.Errorf("Unexpected request %+v", req)
+    }
+    var doc map[string]any
+    if err := json.Unmarshal(corpo, &doc); err != nil || doc["texto"] != "Disparo \"zona\" 5" || doc["conta"] != 1234.0 {
+        t.Errorf("Unexpected body %s", corpo)
+    }
+    mac := hmac.New(sha256.New, []byte("segredo"))
+    mac.Write(corpo)
+    if req.Header.Get(CabecalhoAssinatura) != "sha256=" + hex.EncodeToString(mac.Sum(nil)) {
+        t.Errorf("Bad signature %s", req.Header.Get(CabecalhoAssinatura))
+    }
+}
+
+func TestWebhookFalhas(t *testing.T) {
+    var tentativas atomic.Int32
+    status := http.StatusInternalServerError
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+        tentativas.Add(1)
+        w.WriteHeader(status)
+    }))
+    defer srv.Close()
+
+    falhas := filepath.Join(t.TempDir(), "falhas.jsonl")
+    cfg := ConfigWebhook{Nome: "teste", URL: srv.URL, Metodo: "POST", Timeout: time.Second, Tentativas: 3,
+        Intervalo: 10 * time.Millisecond, ArquivoFalhas: falhas}
+    w, _ := NewWebhook(cfg)
+
+    // esgotadas as tentativas, o evento vai para o arquivo de falhas
+    if err := w.Consumir(DadosGancho{Tipo: "ev", Mac: "aa:bb:cc"}); !errors.Is(err, ErrEventoArquivado) {
+        t.Fatal(err)
+    }
+    if tentativas.Load() != 3 {
+        t.Errorf("Expected 3 attempts, got %d", tentativas.Load())
+    }
+    linhas := aguardalinhas(t, falhas, 1)
+    var falha FalhaWebhook
+    if err := json.Unmarshal([]byte(linhas[0]), &falha); err != nil || falha.Dados.Mac != "aa:bb:cc" ||
+            !strings.Contains(falha.Erro, "500") || falha.Webhook != "teste" {
+        t.Errorf("Unexpected dead letter %s", linhas[0])
+    }
+
+    // erro permanente não é repetido
+    status = http.StatusBadRequest
+    tentativas.Store(0)
+    w.Consumir(DadosGancho{Tipo: "ev"})
+    if tentativas.Load() != 1 {
+        t.Errorf("Expected 1 attempt, got %d", tentativas.Load())
+    }
+
+    // sem arquivo de falhas, o erro é informado ao despachante
+    cfg.ArquivoFalhas = ""
+    w, _ = NewWebhook(cfg)
+    if err := w.Consumir(DadosGancho{Tipo: "ev"}); err == nil || errors.Is(err, ErrEventoArquivado) {
+        t.Error("Should have failed without dead letter", err)
+    }
+}
+
+func TestWebhookReceptor(t *testing.T) {
+    corpos := make(chan []byte, 10)
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+        corpo, _ := io.ReadAll(req.Body)
+        corpos <- corpo
+    }))
+    defer srv.Close()
+
+    r := receptorteste(t, "[webhook eventos]\ntipos = ev\nurl = " + srv.URL + "\n")
+    c := centralfake_receptor(t, r, []byte{0xaa, 0xbb, 0x07}, func(int, []byte) (int, []byte) {
+        return 0, nil
+    })
+    c.Write(pacoteevento(130, 1, 5))
+
+    select {
+    case corpo := <-corpos:
+        var dados DadosGancho
+        if err := json.Unmarshal(corpo, &dados); err != nil || dados.Tipo != "ev" || dados.Mac != "aa:bb:07" ||
+                dados.Evento == nil || dados.Evento.Zona != 5 {
+            t.Errorf("Unexpected body %s", corpo)
+        }
+    case <-time.After(2 * time.Second):
+        t.Error("Webhook not called")
+    }
+}
+
+func TestConfigWebhook(t *testing.T) {
+    cfg, err := NewReceptorIPConfig(strings.NewReader("[receptorip]\n[webhook telegram]\ntipos = msg, ev\n" +
+        "url = https://exemplo/x\nmetodo = put\ncabecalho_Authorization = Bearer a:b\n" +
+        "corpo = {\"text\": {{json .Mensagem}}}\ntimeout = 2.5\ntentativas = 5\narquivo_falhas = f.jsonl\n" +
+        "[webhook]\nurl = http://localhost/y\n[outra]\nx = 1\n"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(cfg.Webhooks) != 2 {
+        t.Fatalf("Unexpected webhooks %+v", cfg.Webhooks)
+    }
+    // ordem das seções não é preservada
+    slices.SortFunc(cfg.Webhooks, func(a, b ConfigWebhook) int { return strings.Compare(a.Nome, b.Nome) })
+    w := cfg.Webhooks[0]
+    if w.Nome != "telegram" || len(w.Tipos) != 2 || w.Metodo != "PUT" || w.Cabecalhos["Authorization"] != "Bearer a:b" ||
+            w.Timeout != 2500 * time.Millisecond || w.Tentativas != 5 || w.ArquivoFalhas != "f.jsonl" {
+        t.Errorf("Unexpected webhook %+v", w)
+    }
+    if cfg.Webhooks[1].Nome != "webhook" || cfg.Webhooks[1].Metodo != "POST" || len(cfg.Webhooks[1].Tipos) != 0 {
+        t.Errorf("Unexpected webhook %+v", cfg.Webhooks[1])
+    }
+
+    for _, invalido := range []string{"tipos = ev\n", "url = http://x\ntipos = foo\n", "url = http://x\ncorpo = {{.\n"} {
+        if _, err := NewReceptorIPConfig(strings.NewReader("[receptorip]\n[webhook]\n" + invalido)); err == nil {
+            t.Errorf("Should have failed: %s", invalido)
+        }
+    }
+}
@@ -19,6 +19,12 @@ type TCPClient struct {
 // Creates a new TCPClient, that will embed a TCPSession if connection is successful
 // User should handle Connected || NotConnected events, and the TCPSession events after Connected
 func NewTCPClient(addr string) *TCPClient {
+    return NewTCPClientContext(context.Background(), addr)
+}
+
+// Same as NewTCPClient, but connection attempt is bound to the context
+// If context has no deadline, the default connection timeout applies
+func NewTCPClientContext(parent_ctx context.Context, addr string) *TCPClient {
     h := new(TCPClient)
     h.Session = NewTCPSession(nil)
 
@@ -26,14 +32,19 @@ func NewTCPClient(addr string) *TCPClient {
     // regardless of TCPClient or TCPSession being in charge
     h.Events = h.Session.Events
 
-    // FIXME allow to configure connection timeout, or allow to pass a context
     h.conntimeout = 60 * time.Second
     // With buffer because reading connection result may be well after
     h.result = make(chan string, 1)
 
     log.Printf("TCPClient %p ==================", h)
 
-    ctx, ctx_cancel := context.WithTimeout(context.Background(), h.conntimeout)
+    var ctx context.Context
+    var ctx_cancel context.CancelFunc
+    if _, ok := parent_ctx.Deadline(); ok {
+        ctx, ctx_cancel = context.WithCancel(parent_ctx)
+    } else {
+        ctx, ctx_cancel = context.WithTimeout(parent_ctx, h.conntimeout)
+    }
     h.cancel = ctx_cancel
 
     go func() {
@@ -43,6 +54,8 @@ func NewTCPClient(addr string) *TCPClient {
         conn, err := dialer.DialContext(ctx, "tcp", addr)
         if err != nil {
             log.Printf("TCPClient %p: conn fail %v", h, err) // including ctx cancellation
+            // Session will never be started, so timeouts must be stopped here
+            h.Session.timeouts.DisownAll()
             h.Events <- Event{"NotConnected", nil}
             close(h.Events) // user disengages
             h.result <- "-"
@@ -78,7 +91,10 @@ func (h *TCPClient) Close() {
     // cancel context, if still relevant, to provoke closure of connect goroutine
     h.cancel()
     // wait for connection goroutine to report, or get the past report
-    <-h.result
+    if <-h.result == "-" {
+        // Events channel already closed, session never started
+        return
+    }
     h.Session.Close()
 }
 
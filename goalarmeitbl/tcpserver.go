@@ -5,6 +5,7 @@ import (
     "time"
     "log"
     "errors"
+    "sync"
 )
 
 type TCPServer struct {
@@ -12,6 +13,8 @@ type TCPServer struct {
     listener net.Listener
     timeouts *Parent            // Timeouts associated with this server
     sessions *Parent            // Sessions associated with this server
+    mutex sync.Mutex            // concurrency between ChildDied() and Events closure
+    closed bool
 }
 
 // Create TCP server
@@ -51,7 +54,10 @@ func NewTCPServer(addr string) (*TCPServer, error) {
         listener.Close()
         s.timeouts.DisownAll()
         s.sessions.DisownAll()
+        s.mutex.Lock()
+        s.closed = true
         close(s.Events) // disengage user
+        s.mutex.Unlock()
 
         log.Printf("TCPServer: exited")
     }()
@@ -63,6 +69,12 @@ func NewTCPServer(addr string) (*TCPServer, error) {
 // Called back by TCPServer.sessions, before s.timeouts.DisownAll()
 func (s *TCPServer) ChildDied(_ string, _ string, child Child) {
     session := child.(*TCPSession)
+    // a session may die while the server is shutting down; the user drains Events until closed
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    if s.closed {
+        return
+    }
     s.Events <-Event{"Closed", session}
     // log.Printf("TCPServer %p: closed TCPSession %p", s, session)
 }
@@ -75,6 +87,11 @@ func (s *TCPServer) Timeout(avgto time.Duration, fudge time.Duration, cbchmsg st
     return to
 }
 
+// Local address the server is listening on (e.g. to find out the port when listening on port 0)
+func (s *TCPServer) Addr() net.Addr {
+    return s.listener.Addr()
+}
+
 // Stops TCP server. It is guaranteed that no new Events are emitted after this.
 // Sessions already accepted by the user are not affected.
 func (s *TCPServer) Close() {
@@ -5,6 +5,9 @@ import (
     "log"
     "bytes"
     "slices"
+    "io"
+    "net"
+    "time"
 )
 
 var (
@@ -185,3 +188,43 @@ func TestTCPServer(t *testing.T) {
     }
     <-server_stopped
 }
+
+// Session accepted before the server is closed, and aborted afterwards (e.g. at application shutdown)
+func TestTCPServerShutdown(t *testing.T) {
+    srv, err := NewTCPServer("127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    addr := srv.Addr().(*net.TCPAddr)
+    if addr.Port == 0 {
+        t.Fatal("Listening port not reported")
+    }
+
+    c, err := net.Dial("tcp", addr.String())
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer c.Close()
+    evt := <-srv.Events
+    if evt.Name != "New" {
+        t.Fatal("TCP Server unexpected event", evt.Name)
+    }
+    session := evt.Cargo.(*TCPSession)
+
+    srv.Close()
+
+    // Abort() may be called from any goroutine; the owner still gets an event and closes
+    go session.Abort()
+    for evt := range session.Events {
+        if evt.Name == "Err" || evt.Name == "RecvEof" {
+            break
+        }
+    }
+    // must not notify the closed server
+    session.Close()
+
+    c.SetReadDeadline(time.Now().Add(2 * time.Second))
+    if _, err := io.ReadAll(c); err != nil {
+        t.Errorf("Connection should have been closed: %v", err)
+    }
+}
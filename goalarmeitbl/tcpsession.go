@@ -190,6 +190,19 @@ func (h *TCPSession) Close() {
     log.Printf("TCPSession %p: exited -------------", h)
 }
 
+// Aborts the connection. Unlike Close(), may be called from any goroutine. The user still
+// receives an "Err" or "RecvEof" event and must call Close() to release resources
+func (h *TCPSession) Abort() {
+    if h.conn != nil {
+        h.conn.Close()
+    }
+}
+
+// Remote address of the connection. Must be called after Start()
+func (h *TCPSession) RemoteAddr() net.Addr {
+    return h.conn.RemoteAddr()
+}
+
 // Create new Timeout owned by this session
 func (h *TCPSession) Timeout(avgto time.Duration, fudge time.Duration, cbchmsg string) (*Timeout) {
     to := NewTimeout(avgto, fudge, h.Events, cbchmsg, h.timeouts)
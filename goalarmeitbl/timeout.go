@@ -22,6 +22,8 @@ type Timeout struct {
     impl *time.Timer
     alive bool
     eta time.Time
+    disowned bool
+    sending sync.WaitGroup      // callback already fired, event being sent
 
     cbch chan Event
     cbchmsg string
@@ -47,6 +49,9 @@ func NewTimeout(avgto time.Duration, fudge time.Duration, cbch chan Event, cbchm
 }
 
 func (timeout *Timeout) _restart() {
+    if timeout.disowned {
+        return
+    }
     if timeout.impl != nil {
         timeout.impl.Stop()
     }
@@ -57,9 +62,15 @@ func (timeout *Timeout) _restart() {
 
     timeout.impl = time.AfterFunc(relative_eta, func() {
         timeout.mutex.Lock()
+        if timeout.disowned {
+            timeout.mutex.Unlock()
+            return
+        }
         timeout.alive = false
+        timeout.sending.Add(1)
         timeout.mutex.Unlock()
         timeout.cbch <- Event{timeout.cbchmsg, timeout}
+        timeout.sending.Done()
     })
 }
 
@@ -110,12 +121,16 @@ func (timeout *Timeout) Free() {
 }
 
 // Called by Parent.DisownAll() - involuntary mass disown of all children
+// Parent is about to close the callback channel, so wait for an event already being sent
+// (parent must still drain the channel) and never fire again, even if restarted
 func (timeout *Timeout) Disowned() {
     timeout.mutex.Lock()
-    defer timeout.mutex.Unlock()
-
     timeout.parent = nil
+    timeout.disowned = true
     timeout._stop()
+    timeout.mutex.Unlock()
+
+    timeout.sending.Wait()
 }
 
 // Returns a unique ChildId
@@ -1,83 +1,143 @@
 package main
 
 import (
+    "context"
     "fmt"
     "strconv"
     "github.com/elvis-epx/alarme-intelbras/goalarmeitbl"
     "log"
     "os"
     "io"
+    "maps"
+    "slices"
+    "flag"
+    "strings"
+    "encoding/json"
 )
 
+var saida_json *bool
+var tentativas *int
+
 func usage(err string) {
-    fmt.Printf("Uso: %s <endereço:porta> <senha> <tamanho senha> <comando> [partição ou zona]\n", os.Args[0])
+    if *saida_json {
+        imprimir_json(goalarmeitbl.RelatorioComando{Sucesso: false, Erro: err})
+        os.Exit(3)
+    }
+    fmt.Printf("Uso: %s [--json] [--tentativas N] <endereço:porta> <senha> <tamanho senha> <comando> [parâmetros] [; <comando> ...]\n", os.Args[0])
     fmt.Println()
-    fmt.Println("O parâmetro Partição/Zona pode ou não ser requerido, a depender do comando")
+    fmt.Println("Os parâmetros requeridos dependem do comando")
+    fmt.Println("Vários comandos separados por ';' são executados numa única sessão")
+    fmt.Println("--json: resultado em formato JSON, para consumo por scripts")
+    fmt.Println("--tentativas: número de tentativas se a central estiver ocupada ou inacessível (default 1)")
     fmt.Println()
     fmt.Println("Comandos disponíveis")
     fmt.Println("--------------------")
-    for comando, descritor := range goalarmeitbl.Subcomandos {
-        fmt.Printf("%s %s\n", comando, descritor.ExtraHelp)
+    for _, comando := range slices.Sorted(maps.Keys(goalarmeitbl.Subcomandos)) {
+        descritor := goalarmeitbl.Subcomandos[comando]
+        fmt.Printf("%s %s %s\n", comando, descritor.Sinopse(), descritor.ExtraHelp)
     }
     fmt.Println()
     fmt.Printf("Erro: %s\n", err)
     os.Exit(3)
 }
 
+func imprimir_json(relatorio goalarmeitbl.RelatorioComando) {
+    saida, err := json.MarshalIndent(relatorio, "", "    ")
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Println(string(saida))
+}
+
+func imprimir_dados(dados any) {
+    switch dados := dados.(type) {
+    case goalarmeitbl.StatusCentral:
+        fmt.Println()
+        fmt.Println()
+        fmt.Print(goalarmeitbl.TextoStatusCentral(dados))
+        fmt.Println()
+    case map[string]string:
+        if arquivo, ok := dados["arquivo"]; ok {
+            fmt.Println("Foto gravada em", arquivo)
+        }
+    }
+}
+
+func imprimir_texto(relatorio goalarmeitbl.RelatorioComando, comandos [][]string) {
+    for i, passo := range relatorio.Passos {
+        imprimir_dados(passo.Dados)
+        if passo.Sucesso {
+            fmt.Printf("[%s] Sucesso\n", strings.Join(comandos[i], " "))
+        } else {
+            fmt.Printf("[%s] Fracasso: %s\n", strings.Join(comandos[i], " "), passo.Erro)
+        }
+    }
+
+    imprimir_dados(relatorio.Dados)
+    if relatorio.Tentativas > 1 {
+        fmt.Printf("Tentativas: %d\n", relatorio.Tentativas)
+    }
+    if relatorio.Sucesso {
+        fmt.Println("Sucesso")
+    } else {
+        fmt.Printf("Erro: %s\n", relatorio.Erro)
+        fmt.Println("Fracasso")
+    }
+}
+
 func main() {
     if os.Getenv("LOGITBL") != "" {
         log.SetOutput(os.Stderr)
     } else {
         log.SetOutput(io.Discard)
     }
-    if len(os.Args) < 5 {
+
+    saida_json = flag.Bool("json", false, "resultado em formato JSON")
+    // default sem novas tentativas: um comando de ativação repetido após falha de conexão
+    // poderia ser executado duas vezes
+    tentativas = flag.Int("tentativas", 1, "número de tentativas")
+    flag.Usage = func() { usage("Opção inválida") }
+    flag.Parse()
+    args := flag.Args()
+
+    if *tentativas < 1 {
+        usage("Número de tentativas inválido")
+    }
+
+    if len(args) < 4 {
         usage("Forneça os parâmetros necessários")
     }
 
-    serveraddr := os.Args[1]
+    serveraddr := args[0]
 
-    senha, err := strconv.Atoi(os.Args[2])
+    senha, err := strconv.Atoi(args[1])
     if err != nil {
         usage("Senha inválida")
     }
 
-    tam_senha, err2 := strconv.Atoi(os.Args[3])
+    tam_senha, err2 := strconv.Atoi(args[2])
     if err2 != nil || (tam_senha != 4 && tam_senha != 6) {
         usage("Tamanho senha inválida")
     }
 
-    comando := os.Args[4]
-
-    descritor, ok := goalarmeitbl.Subcomandos[comando]
-    if !ok {
-        usage("Comando não reconhecido")
-    }
-
-    extra := 0
-    if descritor.ExtraParam {
-        if len(os.Args) > 5 {
-            extra, err = strconv.Atoi(os.Args[5])
-            if err != nil || extra > 255 || extra < 0 {
-                usage("Parâmetro extra inválido")
-            }
-        }
-    } else {
-        if len(os.Args) > 5 {
-            usage("Parâmetro extra desnecessário")
-        }
-    }
-
-    sub, errstring := descritor.Construtor(extra)
+    comandos := goalarmeitbl.SepararComandos(args[3:])
+    subs, errstring := goalarmeitbl.ConstruirComandos(comandos)
     if errstring != "" {
         usage(errstring)
     }
-    
-    c := goalarmeitbl.NewComandoCentral(sub, serveraddr, senha, tam_senha)
+
+    politica := goalarmeitbl.PoliticaRetryDefault
+    politica.Tentativas = *tentativas
+    c := goalarmeitbl.NewSequenciaComandosRetry(context.Background(), subs, serveraddr, senha, tam_senha, politica)
     res := c.Resultado() // bloqueia
-    if (res == 0) {
-        fmt.Println("Sucesso")
+
+    if *saida_json {
+        imprimir_json(c.Relatorio())
     } else {
-        fmt.Println("Fracasso")
+        imprimir_texto(c.Relatorio(), comandos)
+    }
+
+    if (res != 0) {
         os.Exit(2)
     }
 }
@@ -5,15 +5,126 @@ import (
     "github.com/elvis-epx/alarme-intelbras/goalarmeitbl"
     "log"
     "os"
+    "os/signal"
+    "syscall"
     "io"
+    "flag"
+    "strconv"
+    "strings"
+    "time"
+    "encoding/json"
 )
 
 func usage(err string) {
     fmt.Printf("Erro: %s\n", err)
     fmt.Printf("Uso: %s <arquivo de configuração>\n", os.Args[0])
+    fmt.Printf("     %s journal [opções] <arquivo de configuração>\n", os.Args[0])
     os.Exit(3)
 }
 
+func ler_config(arquivo string) goalarmeitbl.ReceptorIPConfig {
+    f, err := os.Open(arquivo)
+    if err != nil {
+        log.Print(err)
+        usage("Arquivo de configuração não pôde ser aberto")
+    }
+    cfg, err := goalarmeitbl.NewReceptorIPConfig(f)
+    if err != nil {
+        usage(fmt.Sprintf("Arquivo de configuração inválido: %v", err))
+    }
+    f.Close()
+    return cfg
+}
+
+// Aceita data ou data e hora, no fuso local
+func ler_horario(s string) (time.Time, error) {
+    for _, formato := range []string{time.DateTime, "2006-01-02T15:04:05", "2006-01-02 15:04", time.DateOnly} {
+        t, err := time.ParseInLocation(formato, s, time.Local)
+        if err == nil {
+            return t, nil
+        }
+    }
+    return time.Time{}, fmt.Errorf("horário inválido %s, use AAAA-MM-DD [HH:MM[:SS]]", s)
+}
+
+// Subcomando de consulta ao journal de eventos
+func journal(args []string) {
+    flags := flag.NewFlagSet("journal", flag.ExitOnError)
+    desde := flags.String("desde", "", "início do período (AAAA-MM-DD [HH:MM[:SS]])")
+    ate := flags.String("ate", "", "fim do período (AAAA-MM-DD [HH:MM[:SS]])")
+    central := flags.String("central", "", "MAC (aa:bb:cc) ou conta da central")
+    codigo := flags.Int("codigo", -1, "código Contact ID do evento")
+    zona := flags.Int("zona", -1, "zona do evento")
+    eventos := flags.Bool("eventos", false, "apenas eventos de alarme, omitindo demais frames")
+    saida_json := flags.Bool("json", false, "uma entrada JSON por linha")
+    flags.Parse(args)
+
+    if flags.NArg() < 1 {
+        usage("arquivo de configuração não especificado")
+    }
+    cfg := ler_config(flags.Arg(0))
+    if cfg.Journal == "" {
+        usage("journal não configurado")
+    }
+
+    filtro := goalarmeitbl.FiltroJournal{Codigo: *codigo, Zona: *zona, SoEventos: *eventos}
+    var err error
+    if *desde != "" {
+        if filtro.Desde, err = ler_horario(*desde); err != nil {
+            usage(err.Error())
+        }
+    }
+    if *ate != "" {
+        if filtro.Ate, err = ler_horario(*ate); err != nil {
+            usage(err.Error())
+        }
+        if len(*ate) == len(time.DateOnly) {
+            // dia inteiro
+            filtro.Ate = filtro.Ate.Add(24 * time.Hour - time.Nanosecond)
+        }
+    }
+    if strings.Contains(*central, ":") {
+        filtro.Mac = strings.ToLower(*central)
+    } else if *central != "" {
+        if filtro.Conta, err = strconv.Atoi(*central); err != nil {
+            usage("central deve ser MAC ou número de conta")
+        }
+    }
+
+    entradas, err := goalarmeitbl.ConsultarJournal(cfg.Journal, filtro)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Erro ao ler journal: %v\n", err)
+    }
+
+    for _, e := range entradas {
+        if *saida_json {
+            linha, _ := json.Marshal(e)
+            fmt.Println(string(linha))
+            continue
+        }
+        central := e.Mac
+        if central == "" {
+            central = "-"
+        }
+        descricao := fmt.Sprintf("frame %02x %s", e.Tipo, e.Hex)
+        if e.Evento != nil && e.Evento.Valido {
+            descricao = e.Evento.DescricaoHumana
+            if !e.Evento.CodigoConhecido {
+                descricao = fmt.Sprintf("evento codigo %d particao %d zona %d qualificador %d",
+                    e.Evento.Codigo, e.Evento.Particao, e.Evento.Zona, e.Evento.Qualificador)
+            }
+            if e.Duplicado {
+                descricao += " (duplicado)"
+            }
+        }
+        fmt.Printf("%s %s %d %s\n", e.Quando.Local().Format(time.DateTime), central, e.Conta, descricao)
+    }
+
+    if err != nil {
+        os.Exit(1)
+    }
+}
+
 func main() {
     if os.Getenv("LOGITBL") != "" {
         log.SetOutput(os.Stderr)
@@ -25,16 +136,12 @@ func main() {
         usage("arquivo de configuração não especificado")
     }
 
-    f, err := os.Open(os.Args[1])
-    if err != nil {
-        log.Print(err)
-        usage("Arquivo de configuração não pôde ser aberto")
-    }
-    cfg, err := goalarmeitbl.NewReceptorIPConfig(f)
-    if err != nil {
-        usage(fmt.Sprintf("Arquivo de configuração inválido: %v", err))
+    if os.Args[1] == "journal" {
+        journal(os.Args[2:])
+        return
     }
-    f.Close()
+
+    cfg := ler_config(os.Args[1])
 
     if cfg.LogLevel != "" {
         log.SetOutput(os.Stderr)
@@ -44,5 +151,13 @@ func main() {
     if err != nil {
         usage(fmt.Sprintf("Falha ao iniciar receptor IP: %v", err))
     }
+
+    sinais := make(chan os.Signal, 1)
+    signal.Notify(sinais, os.Interrupt, syscall.SIGTERM)
+    go func() {
+        sig := <-sinais
+        fmt.Println("Encerrando receptor IP:", sig)
+        srv.Close()
+    }()
     srv.Wait()
 }